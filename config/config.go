@@ -1,7 +1,9 @@
 package config
 
 import (
+	"log"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +16,8 @@ type Config struct {
 	JWTSecret    string        // Secret key for signing JWT tokens (should be kept secure)
 	Port         string        // HTTP server port (e.g., "8080")
 	DailyQuota   time.Duration // Maximum daily motor usage quota per user (e.g., 1 hour)
+	DeviceQuota  time.Duration // Maximum daily run time per device (0 disables the per-device limit)
+	Timezone     string        // Site timezone; daily quotas reset at local midnight (e.g., "Asia/Karachi")
 	MaxRetries   int           // Maximum number of retry attempts for failed operations
 	DebugMode    bool          // Whether to run in debug mode (default: true for development)
 	MQTTUsername string        // MQTT username for authentication
@@ -21,6 +25,30 @@ type Config struct {
 	MQTTHost     string        // MQTT host (e.g., "localhost")
 	MQTTProtocol string        // MQTT protocol (e.g., "ssl", "tcp")
 	MQTTPort     int           // MQTT port (e.g., 8883)
+
+	RecoveryPolicy string        // What to do with sessions left running by a crash: "shutdown" or "resume"
+	OfflineAfter   time.Duration // Silence after which a device is considered offline (0 disables offline detection)
+	ClaimCodeTTL   time.Duration // How long a device claim code stays valid
+	MessageMaxSkew time.Duration // Maximum age of a signed device message (replay window)
+	RequireSigned  bool          // Drop messages from devices that have not been claimed (and so cannot sign)
+
+	FaultAlertInterval time.Duration // How often admins are re-alerted about an unacknowledged device fault
+
+	ShutdownPolicy  string        // What to do with running activations on SIGTERM: "stop" or "handoff"
+	ShutdownTimeout time.Duration // How long a graceful shutdown may take before the process exits anyway
+
+	LeaderLockID        int64         // Postgres advisory lock key held by the leader replica
+	LeaderRetryInterval time.Duration // How often a follower tries to become leader
+
+	BlackoutPolicy     string        // Requests overlapping a blackout: "queue" (move to the next free slot) or "reject"
+	BlackoutStopMargin time.Duration // How long before a blackout running sessions are stopped
+
+	TariffPeakRate    float64 // Electricity price per kWh during peak hours
+	TariffOffPeakRate float64 // Electricity price per kWh outside peak hours
+	TariffPeakHours   string  // Daily peak window in site time, e.g. "17:00-22:00" (empty means no peak)
+	TariffCurrency    string  // Currency label for costs, e.g. "PKR"
+
+	location *time.Location // Site timezone, loaded once from Timezone
 }
 
 // Load reads configuration from environment variables and returns a Config struct
@@ -30,7 +58,7 @@ type Config struct {
 // 3. Converting string values to appropriate types
 // 4. Centralizing all configuration logic
 func Load() *Config {
-	return &Config{
+	cfg := &Config{
 		MQTTHost: getEnv("MQTT_HOST", "localhost"), // MQTT host (e.g., "localhost")
 
 		MQTTProtocol: getEnv("MQTT_PROTOCOL", "tcp"), // MQTT protocol (e.g., "ssl", "tcp")
@@ -50,6 +78,14 @@ func Load() *Config {
 		// Default: 1 hour (prevents abuse and controls costs)
 		DailyQuota: getDurationEnv("DAILY_QUOTA", time.Hour),
 
+		// Device quota - maximum time a single device may run per day, across all users
+		// Default: 0 (no per-device limit)
+		DeviceQuota: getDurationEnv("DEVICE_DAILY_QUOTA", 0),
+
+		// Site timezone - the local day used for quota accounting
+		// Default: "Asia/Karachi" (matches the database session timezone)
+		Timezone: getEnv("SITE_TIMEZONE", "Asia/Karachi"),
+
 		// Max retries - maximum number of retry attempts for failed operations
 		// Default: 3 attempts (reasonable retry limit)
 		MaxRetries: getIntEnv("MAX_RETRIES", 3),
@@ -58,9 +94,84 @@ func Load() *Config {
 		// Default: true for development, should be false in production
 		DebugMode: getBoolEnv("DEBUG_MODE", true),
 
+		// Recovery policy - what startup reconciliation does with sessions a crashed process left running
+		// "shutdown" turns the device OFF, "resume" keeps it ON for the remaining time
+		// Default: "shutdown" (fail safe)
+		RecoveryPolicy: getChoiceEnv("RECOVERY_POLICY", "shutdown", "resume"),
+
+		// Offline threshold - devices that send no status, ACK or heartbeat for this long are marked offline
+		// Default: 2 minutes; set to 0 to disable offline detection
+		OfflineAfter: getDurationEnv("DEVICE_OFFLINE_AFTER", 2*time.Minute),
+
+		// Claim code lifetime - how long an admin-issued provisioning code can be redeemed
+		// Default: 24 hours
+		ClaimCodeTTL: getDurationEnv("CLAIM_CODE_TTL", 24*time.Hour),
+
+		// Message skew - signed status/ACK messages older (or newer) than this are rejected as replays
+		// Default: 5 minutes (allows for device clock drift)
+		MessageMaxSkew: getDurationEnv("DEVICE_MESSAGE_MAX_SKEW", 5*time.Minute),
+
+		// Require signed messages - once every controller is claimed, unsigned status/ACK/heartbeat messages are dropped
+		// Default: false (unclaimed devices may send plain payloads)
+		RequireSigned: getBoolEnv("REQUIRE_SIGNED_MESSAGES", false),
+
+		// Fault alert interval - a device that did not confirm OFF is re-announced to admins this often until acknowledged
+		// Default: 5 minutes
+		FaultAlertInterval: getDurationEnv("FAULT_ALERT_INTERVAL", 5*time.Minute),
+
+		// Shutdown policy - what a graceful shutdown (SIGTERM/SIGINT) does with running activations
+		// "stop" turns the devices OFF and closes their sessions, "handoff" leaves them ON for the next instance to resume
+		// Default: "stop" (fail safe)
+		ShutdownPolicy: getChoiceEnv("SHUTDOWN_POLICY", "stop", "handoff"),
+
+		// Shutdown timeout - how long draining requests and stopping activations may take
+		// Default: 1 minute
+		ShutdownTimeout: getDurationEnv("SHUTDOWN_TIMEOUT", time.Minute),
+
+		// Leader election - only the replica holding this Postgres advisory lock controls devices
+		// Replicas sharing a database must use the same key; followers retry every LEADER_RETRY_INTERVAL
+		// Defaults: key 7275001, retry every 5 seconds
+		LeaderLockID:        int64(getIntEnv("LEADER_LOCK_ID", 7275001)),
+		LeaderRetryInterval: getDurationEnv("LEADER_RETRY_INTERVAL", 5*time.Second),
+
+		// Blackout policy - what happens to activations that would overlap a scheduled power outage
+		// "queue" holds them until the next slot they fit in, "reject" refuses them
+		// Default: "queue"
+		BlackoutPolicy: getChoiceEnv("BLACKOUT_POLICY", "queue", "reject"),
+
+		// Blackout stop margin - running sessions are switched off this long before a blackout starts
+		// Default: 2 minutes
+		BlackoutStopMargin: getDurationEnv("BLACKOUT_STOP_MARGIN", 2*time.Minute),
+
+		// Tariff - used to price the energy of each session
+		// Defaults: no prices set, peak window 17:00-22:00 site time
+		TariffPeakRate:    getFloatEnv("TARIFF_PEAK_RATE", 0),
+		TariffOffPeakRate: getFloatEnv("TARIFF_OFFPEAK_RATE", 0),
+		TariffPeakHours:   getEnv("TARIFF_PEAK_HOURS", "17:00-22:00"),
+		TariffCurrency:    getEnv("TARIFF_CURRENCY", "PKR"),
+
 		MQTTUsername: getEnv("MQTT_USERNAME", "your-hivemq-username"), // MQTT username for authentication
 		MQTTPassword: getEnv("MQTT_PASSWORD", "your-hivemq-password"), // MQTT password for authentication
 	}
+	cfg.location = loadLocation(cfg.Timezone)
+	return cfg
+}
+
+// Location returns the site timezone, falling back to the server's local time if it cannot be loaded
+func (c *Config) Location() *time.Location {
+	if c.location == nil {
+		return loadLocation(c.Timezone)
+	}
+	return c.location
+}
+
+// loadLocation loads a timezone, falling back to the server's local time
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
 }
 
 // getEnv reads an environment variable and returns its value
@@ -72,6 +183,16 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getChoiceEnv reads an environment variable that must be the default value or one of the others.
+// A misspelt policy would otherwise quietly fall back to the default, so the process exits instead.
+func getChoiceEnv(key, defaultValue string, others ...string) string {
+	value := getEnv(key, defaultValue)
+	if value != defaultValue && !slices.Contains(others, value) {
+		log.Fatalf("Invalid %s %q: must be one of %s", key, value, strings.Join(append([]string{defaultValue}, others...), ", "))
+	}
+	return value
+}
+
 // getDurationEnv reads an environment variable and converts it to a time.Duration
 func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
@@ -92,6 +213,16 @@ func getIntEnv(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getFloatEnv reads an environment variable and converts it to a float
+func getFloatEnv(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 // getBoolEnv reads an environment variable and converts it to a boolean
 // Valid values: "true", "false", "1", "0", "yes", "no" (case insensitive)
 func getBoolEnv(key string, defaultValue bool) bool {
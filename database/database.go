@@ -52,6 +52,11 @@ func Connect() error {
 	}
 	DB = db
 
+	// Devices created before per-device control topics existed run single-topic firmware;
+	// note whether this is the upgrade that adds the flag, before AutoMigrate adds it
+	addingLegacyControl := DB.Migrator().HasTable(&models.Device{}) &&
+		!DB.Migrator().HasColumn(&models.Device{}, "LegacyControl")
+
 	// Auto-migrate the database schema based on our models
 	// This ensures our database tables match our Go structs
 	// GORM will create tables, add columns, or modify schema as needed
@@ -62,6 +67,16 @@ func Connect() error {
 		&models.Device{},
 		&models.DeviceLog{},
 		&models.DeviceSession{},
+		&models.QuotaUsage{},
+		&models.ActivationRequest{},
+		&models.ActivationAttempt{},
+		&models.Schedule{},
+		&models.DeviceTelemetry{},
+		&models.Blackout{},
+		&models.DeviceFault{},
+		&models.DeviceStateTransition{},
+		&models.MaintenanceWindow{},
+		&models.ControlCommand{},
 	)
 	if err != nil {
 		// If migration fails, return the error
@@ -69,13 +84,34 @@ func Connect() error {
 		return err
 	}
 
+	// Keep existing devices on the shared "device/control" topic
+	if addingLegacyControl {
+		if err := DB.Model(&models.Device{}).Where("1 = 1").Update("legacy_control", true).Error; err != nil {
+			return err
+		}
+	}
+
+	// AutoMigrate never changes an existing check constraint, so rebuild the
+	// device state check, mapping states stored before the lifecycle existed
+	if err := migrateDeviceStates(); err != nil {
+		return err
+	}
+
+	// Sessions closed before end times were recorded would otherwise look open
+	if err := migrateSessionEnds(); err != nil {
+		return err
+	}
+
 	// Now insert initial data (e.g., a default Motor device)
+	// The default device runs the original single-topic firmware, so it
+	// keeps listening on the shared "device/control" topic.
 	var count int64
 	DB.Model(&models.Device{}).Where("name = ?", "Motor Pump").Count(&count)
 	if count == 0 {
 		DB.Create(&models.Device{
-			Name:  "Motor Pump",
-			State: "UNKNOWN",
+			Name:          "Motor Pump",
+			State:         models.StateOffline,
+			LegacyControl: true,
 		})
 	}
 
@@ -83,6 +119,33 @@ func Connect() error {
 	return nil
 }
 
+// migrateDeviceStates drops the device state check, maps the old ON/OFF/UNKNOWN
+// states onto the lifecycle states and creates the check again from the model's tags.
+func migrateDeviceStates() error {
+	if err := DB.Exec("ALTER TABLE devices DROP CONSTRAINT IF EXISTS chk_devices_state").Error; err != nil {
+		return err
+	}
+	if err := DB.Exec(`UPDATE devices SET state = CASE
+		WHEN state = 'ON' THEN 'RUNNING'
+		WHEN state = 'OFF' AND online THEN 'IDLE'
+		ELSE 'OFFLINE' END
+		WHERE state IN ('ON', 'OFF', 'UNKNOWN')`).Error; err != nil {
+		return err
+	}
+	return DB.Migrator().CreateConstraint(&models.Device{}, "chk_devices_state")
+}
+
+// migrateSessionEnds fills in ended_at for closed sessions that predate it, from their
+// final OFF log (or their scheduled end if the log is missing).
+func migrateSessionEnds() error {
+	return DB.Exec(`UPDATE device_sessions SET ended_at = COALESCE(
+		(SELECT MAX(device_logs.created_at) FROM device_logs
+			WHERE device_logs.session_id = device_sessions.id AND device_logs.state = 'OFF' AND device_logs.deleted_at IS NULL),
+		active_until)
+		WHERE ended_at IS NULL AND (reason <> '' OR EXISTS (SELECT 1 FROM device_logs
+			WHERE device_logs.session_id = device_sessions.id AND device_logs.state = 'OFF' AND device_logs.deleted_at IS NULL))`).Error
+}
+
 // GetDB returns the global database connection
 // This function provides a clean way for other parts of the application
 // to access the database connection without directly accessing the global variable
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/services"
+)
+
+// AckStatsHandler reports the stray, duplicate and unknown ACKs seen since startup (admin only).
+// ACKs are only handled by the leader, so the counts of other replicas stay at zero.
+func AckStatsHandler(deviceService *services.DeviceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"acks":   deviceService.AckStats(),
+			"leader": services.IsLeader(),
+		})
+	}
+}
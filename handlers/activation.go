@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"github.com/musabgulfam/pumplink-backend/services"
+	"gorm.io/gorm"
+)
+
+// ActivationStatusHandler returns the current status of an activation request.
+// Users can only follow their own requests; admins can follow any request.
+func ActivationStatusHandler(c *gin.Context) {
+	id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid activation ID"})
+		return
+	}
+
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	currentUser := user.(models.User)
+
+	db := database.GetDB()
+	var activation models.ActivationRequest
+	if err := db.Preload("Attempts", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("attempt")
+	}).First(&activation, id64).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Activation not found"})
+		return
+	}
+	if activation.UserID != currentUser.ID && currentUser.Role != models.RoleAdmin {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Activation not found"})
+		return
+	}
+
+	response := gin.H{
+		"id":               activation.ID,
+		"device_id":        activation.DeviceID,
+		"user_id":          activation.UserID,
+		"duration_minutes": activation.Duration.Minutes(),
+		"status":           activation.Status,
+		"reason":           activation.StatusReason,
+		"session_id":       activation.SessionID,
+		"created_at":       activation.CreatedAt,
+		"started_at":       activation.StartedAt,
+		"finished_at":      activation.FinishedAt,
+	}
+	if activation.Status == models.ActivationQueued && activation.NotBefore != nil {
+		response["not_before"] = activation.NotBefore // Held until then (cooldown, blackout or interlock)
+	}
+
+	// Tell queued clients how many requests are ahead of them in their device's lane
+	if activation.Status == models.ActivationQueued {
+		var ahead int64
+		db.Model(&models.ActivationRequest{}).
+			Where("status = ? AND device_id = ? AND id < ?", models.ActivationQueued, activation.DeviceID, activation.ID).
+			Count(&ahead)
+		response["queue_position"] = ahead + 1
+	}
+
+	// Each publish of the ON command, to diagnose flaky connections
+	attempts := make([]gin.H, 0, len(activation.Attempts))
+	for _, attempt := range activation.Attempts {
+		attempts = append(attempts, gin.H{
+			"attempt":         attempt.Attempt,
+			"command_id":      attempt.CommandID,
+			"sent_at":         attempt.SentAt,
+			"timeout_seconds": attempt.Timeout.Seconds(),
+			"outcome":         attempt.Outcome,
+			"error":           attempt.Error,
+			"acked_at":        attempt.AckedAt,
+		})
+	}
+	response["attempts"] = attempts
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Dependency-injected handler for users cancelling their own activation.
+// Queued requests are withdrawn; dispatching or running ones are switched off.
+func CancelActivationHandler(deviceService *services.DeviceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid activation ID"})
+			return
+		}
+
+		activation, err := deviceService.CancelActivation(uint(id64), c.GetUint("userID"))
+		switch err {
+		case nil:
+			message := "Activation is being stopped"
+			if activation.Status == models.ActivationCancelled {
+				message = "Queued activation cancelled"
+			}
+			c.JSON(http.StatusOK, gin.H{"message": message, "activation_id": activation.ID})
+		case services.ErrActivationNotFound, services.ErrNotSessionOwner:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Activation not found"})
+		case services.ErrActivationFinished:
+			c.JSON(http.StatusConflict, gin.H{"error": "Activation has already finished", "status": activation.Status})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+	}
+}
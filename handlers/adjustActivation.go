@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"github.com/musabgulfam/pumplink-backend/services"
+)
+
+type AdjustActivationInput struct {
+	Remaining uint `json:"remaining" binding:"required"` // New remaining run time, in minutes
+}
+
+// Dependency-injected handler for extending or shortening a running activation
+func AdjustActivationHandler(deviceService *services.DeviceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input AdjustActivationInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if input.Remaining > maxRunMinutes {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Remaining run time must be at most 24 hours", "limit": "duration"})
+			return
+		}
+
+		id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+			return
+		}
+		user := c.MustGet("user").(models.User)
+
+		sessionID, activeUntil, err := deviceService.AdjustActivation(
+			uint(id64),
+			user.ID,
+			user.Role == models.RoleAdmin,
+			time.Duration(input.Remaining)*time.Minute,
+		)
+		var limit *services.LimitError
+		if errors.As(err, &limit) {
+			c.JSON(http.StatusUnprocessableEntity, limitErrorResponse(limit))
+			return
+		}
+		var blackout *services.BlackoutError
+		if errors.As(err, &blackout) {
+			c.JSON(http.StatusConflict, blackoutErrorResponse(blackout))
+			return
+		}
+		switch err {
+		case nil:
+			c.JSON(http.StatusOK, gin.H{
+				"message":      "Activation updated",
+				"session_id":   sessionID,
+				"active_until": activeUntil,
+			})
+		case services.ErrNoRunningActivation:
+			c.JSON(http.StatusNotFound, gin.H{"error": "No running activation for this device"})
+		case services.ErrSessionEnded:
+			c.JSON(http.StatusConflict, gin.H{"error": "The activation ended before it could be changed"})
+		case services.ErrNotSessionOwner:
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only change your own activation"})
+		case services.ErrUserQuotaExceeded:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Your daily quota does not allow this extension"})
+		case services.ErrDeviceQuotaExceeded:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Daily quota for this device does not allow this extension"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"github.com/musabgulfam/pumplink-backend/services"
+)
+
+// BlackoutInput is the JSON payload for creating or replacing a blackout.
+// Weekly slots set days_of_week, start_time and end_time; one-off blackouts set starts_at and ends_at.
+type BlackoutInput struct {
+	Name       string `json:"name" binding:"required"`
+	DeviceID   *uint  `json:"device_id"`    // Omit to cover every device
+	DaysOfWeek string `json:"days_of_week"` // e.g. "mon,wed,fri"
+	StartTime  string `json:"start_time"`   // HH:MM, site time
+	EndTime    string `json:"end_time"`     // HH:MM, site time
+	StartsAt   string `json:"starts_at"`    // RFC3339
+	EndsAt     string `json:"ends_at"`      // RFC3339
+	Enabled    *bool  `json:"enabled"`      // Defaults to true
+}
+
+// apply validates the input and copies it onto the blackout
+func (input *BlackoutInput) apply(blackout *models.Blackout) string {
+	if input.DeviceID != nil {
+		if err := database.GetDB().First(&models.Device{}, *input.DeviceID).Error; err != nil {
+			return "Device not found"
+		}
+	}
+	startsAt, err := parseTimestamp(input.StartsAt)
+	if err != nil {
+		return "Invalid starts_at, expected RFC3339"
+	}
+	endsAt, err := parseTimestamp(input.EndsAt)
+	if err != nil {
+		return "Invalid ends_at, expected RFC3339"
+	}
+
+	blackout.Name = input.Name
+	blackout.DeviceID = input.DeviceID
+	blackout.DaysOfWeek = input.DaysOfWeek
+	blackout.StartTime = input.StartTime
+	blackout.EndTime = input.EndTime
+	blackout.StartsAt = startsAt
+	blackout.EndsAt = endsAt
+	blackout.Enabled = input.Enabled == nil || *input.Enabled
+	if blackout.Weekly() {
+		blackout.StartsAt, blackout.EndsAt = nil, nil
+	} else {
+		blackout.StartTime, blackout.EndTime = "", ""
+	}
+	if err := services.ValidateBlackout(blackout); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// parseTimestamp parses an optional RFC3339 timestamp
+func parseTimestamp(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func blackoutResponse(blackout *models.Blackout) gin.H {
+	response := gin.H{
+		"id":           blackout.ID,
+		"name":         blackout.Name,
+		"device_id":    blackout.DeviceID,
+		"weekly":       blackout.Weekly(),
+		"days_of_week": blackout.DaysOfWeek,
+		"start_time":   blackout.StartTime,
+		"end_time":     blackout.EndTime,
+		"starts_at":    blackout.StartsAt,
+		"ends_at":      blackout.EndsAt,
+		"enabled":      blackout.Enabled,
+		"next_window":  nil,
+	}
+	if window := services.NextBlackoutWindow(blackout, time.Now()); window != nil && blackout.Enabled {
+		response["next_window"] = gin.H{"start": window.Start, "end": window.End}
+	}
+	return response
+}
+
+// loadBlackout fetches the blackout in the URL, answering 404 if it does not exist
+func loadBlackout(c *gin.Context) (*models.Blackout, bool) {
+	id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid blackout ID"})
+		return nil, false
+	}
+	var blackout models.Blackout
+	if err := database.GetDB().First(&blackout, id64).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Blackout not found"})
+		return nil, false
+	}
+	return &blackout, true
+}
+
+// ListBlackouts lists the blackout calendar with each entry's next window
+func ListBlackouts(c *gin.Context) {
+	var blackouts []models.Blackout
+	if err := database.GetDB().Order("id").Find(&blackouts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch blackouts"})
+		return
+	}
+	response := make([]gin.H, 0, len(blackouts))
+	for i := range blackouts {
+		response = append(response, blackoutResponse(&blackouts[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"blackouts": response})
+}
+
+// CreateBlackout adds a weekly or one-off blackout (admin only)
+func CreateBlackout(c *gin.Context) {
+	var input BlackoutInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	blackout := models.Blackout{CreatedBy: c.GetUint("userID")}
+	if msg := input.apply(&blackout); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if err := database.GetDB().Create(&blackout).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create blackout"})
+		return
+	}
+	c.JSON(http.StatusCreated, blackoutResponse(&blackout))
+}
+
+// UpdateBlackout replaces a blackout (admin only)
+func UpdateBlackout(c *gin.Context) {
+	blackout, ok := loadBlackout(c)
+	if !ok {
+		return
+	}
+	var input BlackoutInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	if msg := input.apply(blackout); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if err := database.GetDB().Save(blackout).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update blackout"})
+		return
+	}
+	c.JSON(http.StatusOK, blackoutResponse(blackout))
+}
+
+// DeleteBlackout removes a blackout (admin only); held requests are retried when their lane next wakes
+func DeleteBlackout(c *gin.Context) {
+	blackout, ok := loadBlackout(c)
+	if !ok {
+		return
+	}
+	if err := database.GetDB().Delete(blackout).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete blackout"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Blackout deleted"})
+}
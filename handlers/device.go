@@ -1,13 +1,19 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/musabgulfam/pumplink-backend/services"
 )
 
+// maxRunMinutes caps requested run times before they are converted to a time.Duration,
+// so huge values cannot overflow into negative durations that slip past the limit checks.
+const maxRunMinutes = 24 * 60
+
 type DeviceRequestInput struct {
 	DeviceID uint `json:"device_id"`
 	Duration uint `json:"duration"` // in minutes
@@ -21,6 +27,14 @@ func DeviceHandler(deviceService *services.DeviceService) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 			return
 		}
+		if input.Duration == 0 {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Duration must be at least 1 minute", "limit": "duration"})
+			return
+		}
+		if input.Duration > maxRunMinutes {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Duration must be at most 24 hours", "limit": "duration"})
+			return
+		}
 
 		userID, exists := c.Get("userID")
 		if !exists {
@@ -34,16 +48,93 @@ func DeviceHandler(deviceService *services.DeviceService) gin.HandlerFunc {
 			Duration: time.Duration(input.Duration) * time.Minute,
 		}
 
-		err := deviceService.EnqueueActivation(req)
+		activation, err := deviceService.EnqueueActivation(req)
+		var conflict *services.InterlockError
+		if errors.As(err, &conflict) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":    conflict.Error(),
+				"conflict": interlockConflictResponse(conflict),
+			})
+			return
+		}
+		var limit *services.LimitError
+		if errors.As(err, &limit) {
+			c.JSON(http.StatusUnprocessableEntity, limitErrorResponse(limit))
+			return
+		}
+		var blackout *services.BlackoutError
+		if errors.As(err, &blackout) {
+			c.JSON(http.StatusConflict, blackoutErrorResponse(blackout))
+			return
+		}
+		var cooldown *services.CooldownError
+		if errors.As(err, &cooldown) {
+			c.Header("Retry-After", strconv.Itoa(int(cooldown.Remaining.Seconds()+0.5)))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":               cooldown.Error(),
+				"limit":               cooldown.Limit,
+				"retry_after_seconds": int(cooldown.Remaining.Seconds() + 0.5),
+			})
+			return
+		}
 		switch err {
 		case nil:
-			c.JSON(http.StatusOK, gin.H{"message": "Request added to queue"})
-		case services.ErrDeviceAlreadyActive:
-			c.JSON(http.StatusConflict, gin.H{"error": "Device is already active"})
-		case services.ErrQueueFull:
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Queue is full"})
+			response := gin.H{
+				"message":       "Request added to queue",
+				"activation_id": activation.ID,
+				"status":        activation.Status,
+			}
+			// The request waits for a cooldown or behind an interlocked device that is running
+			if activation.StatusReason != "" {
+				response["status_reason"] = activation.StatusReason
+			}
+			c.JSON(http.StatusOK, response)
+		case services.ErrDeviceNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		case services.ErrDeviceOffline:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Device is offline"})
+		case services.ErrDeviceInMaintenance:
+			c.JSON(http.StatusConflict, gin.H{"error": "Device is under maintenance"})
+		case services.ErrDeviceFault:
+			c.JSON(http.StatusConflict, gin.H{"error": "Device is in FAULT: it did not confirm its last OFF command. An admin must check it first."})
+		case services.ErrUserQuotaExceeded:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Your daily quota has been used up"})
+		case services.ErrDeviceQuotaExceeded:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Daily quota for this device has been used up"})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		}
 	}
 }
+
+func interlockConflictResponse(conflict *services.InterlockError) gin.H {
+	return gin.H{
+		"interlock_group": conflict.Group,
+		"device_id":       conflict.ConflictID,
+		"device_name":     conflict.ConflictName,
+	}
+}
+
+// limitErrorResponse names the run time limit a request broke, with durations in minutes
+func limitErrorResponse(limit *services.LimitError) gin.H {
+	return gin.H{
+		"error":             limit.Error(),
+		"limit":             limit.Limit,
+		"allowed_minutes":   limit.Allowed.Minutes(),
+		"requested_minutes": limit.Requested.Minutes(),
+	}
+}
+
+// blackoutErrorResponse describes the blackout a request ran into and when it could start instead
+func blackoutErrorResponse(blackout *services.BlackoutError) gin.H {
+	return gin.H{
+		"error": blackout.Error(),
+		"blackout": gin.H{
+			"id":    blackout.Window.BlackoutID,
+			"name":  blackout.Window.Name,
+			"start": blackout.Window.Start,
+			"end":   blackout.Window.End,
+		},
+		"next_slot": blackout.NextSlot,
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"github.com/musabgulfam/pumplink-backend/services"
+)
+
+// DeviceStateHandler returns a device's lifecycle state and its transitions, newest first.
+// Optional filter: limit (default 50, at most 500).
+func DeviceStateHandler(c *gin.Context) {
+	id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	var device models.Device
+	if err := database.GetDB().First(&device, id64).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	limit := 50
+	if value, err := strconv.Atoi(c.Query("limit")); err == nil && value > 0 && value <= 500 {
+		limit = value
+	}
+	transitions, err := services.DeviceStateHistory(device.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch state history"})
+		return
+	}
+
+	history := make([]gin.H, 0, len(transitions))
+	for _, transition := range transitions {
+		history = append(history, gin.H{
+			"from":       transition.FromState,
+			"to":         transition.ToState,
+			"cause":      transition.Cause,
+			"actor_id":   transition.ActorID,
+			"session_id": transition.SessionID,
+			"detail":     transition.Detail,
+			"changed_at": transition.CreatedAt,
+		})
+	}
+
+	response := gin.H{
+		"device_id":   device.ID,
+		"state":       device.State,
+		"online":      device.Online,
+		"transitions": history,
+	}
+	if len(transitions) > 0 {
+		response["since"] = transitions[0].CreatedAt
+	}
+	c.JSON(http.StatusOK, response)
+}
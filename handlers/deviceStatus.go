@@ -22,8 +22,13 @@ func DeviceStatusHandler(c *gin.Context) {
 		return
 	}
 
-	if deviceModel.State == "OFF" {
-		c.JSON(http.StatusOK, gin.H{"device_id": deviceID, "status": "OFF"})
+	if deviceModel.State != models.StateRunning {
+		c.JSON(http.StatusOK, gin.H{
+			"device_id":    deviceID,
+			"status":       deviceModel.State,
+			"online":       deviceModel.Online,
+			"last_seen_at": deviceModel.LastSeenAt,
+		})
 		return
 	}
 
@@ -49,5 +54,7 @@ func DeviceStatusHandler(c *gin.Context) {
 		"active_until":      latestLog.DeviceSession.ActiveUntil,
 		"intended_duration": latestLog.DeviceSession.IntendedDuration,
 		"session_id":        latestLog.SessionID,
+		"online":            deviceModel.Online,
+		"last_seen_at":      deviceModel.LastSeenAt,
 	})
 }
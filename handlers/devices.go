@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"github.com/musabgulfam/pumplink-backend/services"
+)
+
+// DeviceInput is the JSON payload for creating or updating a device's settings
+type DeviceInput struct {
+	Name                 string   `json:"name" binding:"required"`
+	ControlTopic         string   `json:"control_topic"`  // e.g. "device/{id}/control"; empty uses the default
+	LegacyControl        bool     `json:"legacy_control"` // Use the shared "device/control" topic
+	RatedPowerKW         *float64 `json:"rated_power_kw"`
+	MinRunTime           uint     `json:"min_run_time"`           // in minutes, 0 = no limit
+	MaxRunTime           uint     `json:"max_run_time"`           // in minutes, 0 = no limit
+	MaxContinuousRuntime uint     `json:"max_continuous_runtime"` // in minutes, across back-to-back sessions, 0 = no limit
+	MinFlowRate          *float64 `json:"min_flow_rate"`
+	FlowGracePeriod      uint     `json:"flow_grace_period"` // in seconds
+	MaxCurrent           *float64 `json:"max_current"`
+	MinVoltage           *float64 `json:"min_voltage"`
+	InterlockGroup       string   `json:"interlock_group"`
+	InterlockPolicy      string   `json:"interlock_policy"` // "queue" (default) or "reject"
+	MinOffTime           uint     `json:"min_off_time"`     // in minutes, 0 = no limit
+	MaxStartsPerHour     int      `json:"max_starts_per_hour"`
+	CooldownPolicy       string   `json:"cooldown_policy"` // "queue" (default) or "reject"
+	AckTimeout           uint     `json:"ack_timeout"`     // in seconds, 0 = default; doubles on each retry up to 2 minutes
+}
+
+// deviceSettingColumns are the columns DeviceInput controls; live state is left alone
+var deviceSettingColumns = []string{
+	"Name", "ControlTopic", "LegacyControl", "RatedPowerKW", "MinRunTime", "MaxRunTime", "MaxContinuousRuntime",
+	"MinFlowRate", "FlowGracePeriod", "MaxCurrent", "MinVoltage",
+	"InterlockGroup", "InterlockPolicy", "MinOffTime", "MaxStartsPerHour", "CooldownPolicy",
+	"AckTimeout",
+}
+
+// apply validates the input and copies it onto the device
+func (input *DeviceInput) apply(device *models.Device) string {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return "Name is required"
+	}
+	if input.ControlTopic != "" && strings.ContainsAny(input.ControlTopic, "+#") {
+		return "control_topic must not contain MQTT wildcards"
+	}
+	for _, value := range []*float64{input.RatedPowerKW, input.MinFlowRate, input.MaxCurrent, input.MinVoltage} {
+		if value != nil && *value < 0 {
+			return "Power and protection thresholds must not be negative"
+		}
+	}
+	if input.MaxRunTime > 0 && input.MinRunTime > input.MaxRunTime {
+		return "min_run_time must not be above max_run_time"
+	}
+	if input.MaxStartsPerHour < 0 {
+		return "max_starts_per_hour must not be negative"
+	}
+	interlockPolicy, ok := parsePolicy(input.InterlockPolicy)
+	if !ok {
+		return "interlock_policy must be \"queue\" or \"reject\""
+	}
+	cooldownPolicy, ok := parsePolicy(input.CooldownPolicy)
+	if !ok {
+		return "cooldown_policy must be \"queue\" or \"reject\""
+	}
+
+	device.Name = name
+	device.ControlTopic = input.ControlTopic
+	device.LegacyControl = input.LegacyControl
+	device.RatedPowerKW = input.RatedPowerKW
+	device.MinRunTime = time.Duration(input.MinRunTime) * time.Minute
+	device.MaxRunTime = time.Duration(input.MaxRunTime) * time.Minute
+	device.MaxContinuousRuntime = time.Duration(input.MaxContinuousRuntime) * time.Minute
+	device.MinFlowRate = input.MinFlowRate
+	device.FlowGracePeriod = time.Duration(input.FlowGracePeriod) * time.Second
+	device.MaxCurrent = input.MaxCurrent
+	device.MinVoltage = input.MinVoltage
+	device.InterlockGroup = strings.TrimSpace(input.InterlockGroup)
+	device.InterlockPolicy = interlockPolicy
+	device.MinOffTime = time.Duration(input.MinOffTime) * time.Minute
+	device.MaxStartsPerHour = input.MaxStartsPerHour
+	device.CooldownPolicy = cooldownPolicy
+	device.AckTimeout = time.Duration(input.AckTimeout) * time.Second
+	return ""
+}
+
+// deviceInputFrom returns the current settings of a device as a DeviceInput, so that an
+// update only changes the fields the request sends
+func deviceInputFrom(device *models.Device) DeviceInput {
+	return DeviceInput{
+		Name:                 device.Name,
+		ControlTopic:         device.ControlTopic,
+		LegacyControl:        device.LegacyControl,
+		RatedPowerKW:         device.RatedPowerKW,
+		MinRunTime:           uint(device.MinRunTime / time.Minute),
+		MaxRunTime:           uint(device.MaxRunTime / time.Minute),
+		MaxContinuousRuntime: uint(device.MaxContinuousRuntime / time.Minute),
+		MinFlowRate:          device.MinFlowRate,
+		FlowGracePeriod:      uint(device.FlowGracePeriod / time.Second),
+		MaxCurrent:           device.MaxCurrent,
+		MinVoltage:           device.MinVoltage,
+		InterlockGroup:       device.InterlockGroup,
+		InterlockPolicy:      device.InterlockPolicy,
+		MinOffTime:           uint(device.MinOffTime / time.Minute),
+		MaxStartsPerHour:     device.MaxStartsPerHour,
+		CooldownPolicy:       device.CooldownPolicy,
+		AckTimeout:           uint(device.AckTimeout / time.Second),
+	}
+}
+
+// parsePolicy validates a queue/reject policy, defaulting to queue
+func parsePolicy(value string) (string, bool) {
+	switch value {
+	case "":
+		return models.PolicyQueue, true
+	case models.PolicyQueue, models.PolicyReject:
+		return value, true
+	}
+	return "", false
+}
+
+func deviceResponse(device *models.Device) gin.H {
+	return gin.H{
+		"id":                     device.ID,
+		"name":                   device.Name,
+		"state":                  device.State,
+		"online":                 device.Online,
+		"last_seen_at":           device.LastSeenAt,
+		"control_topic":          services.DeviceControlTopic(device),
+		"legacy_control":         device.LegacyControl,
+		"rated_power_kw":         device.RatedPowerKW,
+		"min_run_time":           device.MinRunTime.Minutes(),
+		"max_run_time":           device.MaxRunTime.Minutes(),
+		"max_continuous_runtime": device.MaxContinuousRuntime.Minutes(),
+		"min_flow_rate":          device.MinFlowRate,
+		"flow_grace_period":      device.FlowGracePeriod.Seconds(),
+		"max_current":            device.MaxCurrent,
+		"min_voltage":            device.MinVoltage,
+		"interlock_group":        device.InterlockGroup,
+		"interlock_policy":       device.InterlockPolicy,
+		"min_off_time":           device.MinOffTime.Minutes(),
+		"max_starts_per_hour":    device.MaxStartsPerHour,
+		"cooldown_policy":        device.CooldownPolicy,
+		"ack_timeout":            device.AckTimeout.Seconds(),
+		"claimed_at":             device.ClaimedAt,
+	}
+}
+
+// loadDevice fetches the device in the URL, answering 404 if it does not exist
+func loadDevice(c *gin.Context) (*models.Device, bool) {
+	id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return nil, false
+	}
+	var device models.Device
+	if err := database.GetDB().First(&device, id64).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return nil, false
+	}
+	return &device, true
+}
+
+// ListDevices lists all devices with their live state and current session, if any
+func ListDevices(c *gin.Context) {
+	db := database.GetDB()
+	var devices []models.Device
+	if err := db.Order("id").Find(&devices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch devices"})
+		return
+	}
+
+	var openSessions []models.DeviceSession
+	if err := db.Where("ended_at IS NULL").Find(&openSessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+	sessionByDevice := make(map[uint]*models.DeviceSession, len(openSessions))
+	for i := range openSessions {
+		sessionByDevice[openSessions[i].DeviceID] = &openSessions[i]
+	}
+
+	type queueCount struct {
+		DeviceID uint
+		Queued   int64
+	}
+	var counts []queueCount
+	if err := db.Model(&models.ActivationRequest{}).
+		Select("device_id, COUNT(*) AS queued").
+		Where("status = ?", models.ActivationQueued).
+		Group("device_id").
+		Scan(&counts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch queue"})
+		return
+	}
+	queuedByDevice := make(map[uint]int64, len(counts))
+	for _, count := range counts {
+		queuedByDevice[count.DeviceID] = count.Queued
+	}
+
+	response := make([]gin.H, 0, len(devices))
+	for i := range devices {
+		entry := gin.H{
+			"id":              devices[i].ID,
+			"name":            devices[i].Name,
+			"state":           devices[i].State,
+			"online":          devices[i].Online,
+			"last_seen_at":    devices[i].LastSeenAt,
+			"interlock_group": devices[i].InterlockGroup,
+			"queued":          queuedByDevice[devices[i].ID],
+		}
+		if session, running := sessionByDevice[devices[i].ID]; running {
+			entry["session_id"] = session.ID
+			entry["user_id"] = session.UserID
+			entry["started_at"] = session.StartedAt
+			entry["active_until"] = session.ActiveUntil
+		}
+		response = append(response, entry)
+	}
+	c.JSON(http.StatusOK, gin.H{"devices": response})
+}
+
+// GetDevice returns a single device with its settings (admin only)
+func GetDevice(c *gin.Context) {
+	device, ok := loadDevice(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, deviceResponse(device))
+}
+
+// CreateDevice registers a new device (admin only)
+func CreateDevice(c *gin.Context) {
+	var input DeviceInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	device := models.Device{State: models.StateOffline}
+	if msg := input.apply(&device); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if err := database.GetDB().Create(&device).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
+		return
+	}
+
+	// The installer redeems this code from the device to get its credentials
+	response := deviceResponse(&device)
+	code, expiresAt, err := services.IssueClaimCode(device.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Device created, but failed to issue a claim code"})
+		return
+	}
+	response["claim_code"] = code
+	response["claim_code_expires_at"] = expiresAt
+	c.JSON(http.StatusCreated, response)
+}
+
+// IssueClaimCode issues a new one-time claim code for a device, e.g. when its controller is replaced (admin only)
+func IssueClaimCode(c *gin.Context) {
+	device, ok := loadDevice(c)
+	if !ok {
+		return
+	}
+	code, expiresAt, err := services.IssueClaimCode(device.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue claim code"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":             device.ID,
+		"claim_code":            code,
+		"claim_code_expires_at": expiresAt,
+	})
+}
+
+// ProvisionDevice lets a device redeem its claim code for its ID and secret (no user authentication)
+func ProvisionDevice(c *gin.Context) {
+	var input struct {
+		ClaimCode string `json:"claim_code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	provisioned, err := services.ClaimDevice(input.ClaimCode)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, provisioned)
+	case services.ErrInvalidClaimCode:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired claim code"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision device"})
+	}
+}
+
+// UpdateDevice changes a device's settings (admin only). Fields left out of the request keep
+// their current values; send null to clear a threshold. A running activation keeps the
+// settings it started with; the new ones apply from the next activation.
+func UpdateDevice(c *gin.Context) {
+	device, ok := loadDevice(c)
+	if !ok {
+		return
+	}
+	input := deviceInputFrom(device)
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	if msg := input.apply(device); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if err := database.GetDB().Model(device).Select(deviceSettingColumns).Updates(device).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+		return
+	}
+	c.JSON(http.StatusOK, deviceResponse(device))
+}
+
+// RetireDeviceHandler removes a device that is not running (admin only)
+func RetireDeviceHandler(deviceService *services.DeviceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		device, ok := loadDevice(c)
+		if !ok {
+			return
+		}
+		switch err := deviceService.RetireDevice(device.ID); err {
+		case nil:
+			c.JSON(http.StatusOK, gin.H{"message": "Device retired"})
+		case services.ErrDeviceNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		case services.ErrDeviceBusy:
+			c.JSON(http.StatusConflict, gin.H{"error": "Device is running; stop it before retiring it"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retire device"})
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/services"
+)
+
+// FaultAcknowledgeInput is the body of a fault acknowledgement. Clear confirms the
+// device is really OFF (e.g. after an on-site check) and returns it to service.
+type FaultAcknowledgeInput struct {
+	Clear bool `json:"clear"`
+}
+
+// AcknowledgeFaultHandler stops the repeated alerts for a device in FAULT (admin only).
+func AcknowledgeFaultHandler(deviceService *services.DeviceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists || userID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+			return
+		}
+
+		// The body is optional; without it the fault is only acknowledged
+		var input FaultAcknowledgeInput
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&input); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		fault, err := deviceService.AcknowledgeFault(uint(id64), userID.(uint), input.Clear)
+		if err == services.ErrNoOpenFault {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device has no open fault"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to acknowledge fault"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"fault_id":        fault.ID,
+			"device_id":       fault.DeviceID,
+			"detail":          fault.Detail,
+			"alert_count":     fault.AlertCount,
+			"acknowledged_by": fault.AcknowledgedBy,
+			"acknowledged_at": fault.AcknowledgedAt,
+			"resolved_at":     fault.ResolvedAt,
+			"resolution":      fault.Resolution,
+		})
+	}
+}
@@ -28,7 +28,7 @@ func ForceShutdownHandler(deviceService *services.DeviceService) gin.HandlerFunc
 		deviceID := uint(id64)
 
 		// Call the service to force shutdown
-		ok := deviceService.ForceShutdown(deviceID)
+		ok := deviceService.ForceShutdown(deviceID, userID.(uint))
 		if ok {
 			c.JSON(http.StatusOK, gin.H{"message": "Device activation forcefully stopped"})
 		} else {
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"github.com/musabgulfam/pumplink-backend/services"
+)
+
+// MaintenanceInput is the optional body for starting maintenance.
+type MaintenanceInput struct {
+	Note string `json:"note"` // What is being done, shown in the maintenance log
+}
+
+// maintenanceWindowResponse formats a maintenance window for the API
+func maintenanceWindowResponse(window *models.MaintenanceWindow) gin.H {
+	return gin.H{
+		"id":         window.ID,
+		"device_id":  window.DeviceID,
+		"note":       window.Note,
+		"started_by": window.StartedBy,
+		"started_at": window.StartedAt,
+		"ended_by":   window.EndedBy,
+		"ended_at":   window.EndedAt,
+	}
+}
+
+// StartMaintenanceHandler puts a device under maintenance, stopping it if it is running (admin only).
+func StartMaintenanceHandler(deviceService *services.DeviceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists || userID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+			return
+		}
+
+		var input MaintenanceInput
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&input); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		window, err := deviceService.StartMaintenance(uint(id64), userID.(uint), input.Note)
+		switch err {
+		case nil:
+			c.JSON(http.StatusOK, maintenanceWindowResponse(window))
+		case services.ErrDeviceNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		case services.ErrDeviceInMaintenance:
+			c.JSON(http.StatusConflict, gin.H{"error": "Device is already under maintenance"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start maintenance"})
+		}
+	}
+}
+
+// EndMaintenanceHandler returns a device to service (admin only).
+func EndMaintenanceHandler(deviceService *services.DeviceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists || userID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+			return
+		}
+
+		window, err := deviceService.EndMaintenance(uint(id64), userID.(uint))
+		switch err {
+		case nil:
+			c.JSON(http.StatusOK, maintenanceWindowResponse(window))
+		case services.ErrNotInMaintenance:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device is not under maintenance"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end maintenance"})
+		}
+	}
+}
+
+// MaintenanceHistoryHandler lists a device's maintenance windows, newest first.
+// Optional filter: limit (default 50, at most 500).
+func MaintenanceHistoryHandler(c *gin.Context) {
+	id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+	limit := 50
+	if value, err := strconv.Atoi(c.Query("limit")); err == nil && value > 0 && value <= 500 {
+		limit = value
+	}
+
+	windows, err := services.MaintenanceHistory(uint(id64), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch maintenance history"})
+		return
+	}
+	response := make([]gin.H, 0, len(windows))
+	for i := range windows {
+		response = append(response, maintenanceWindowResponse(&windows[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"maintenance": response})
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"github.com/musabgulfam/pumplink-backend/services"
+)
+
+// ScheduleInput is the JSON payload for creating or replacing a schedule
+type ScheduleInput struct {
+	DeviceID   uint   `json:"device_id" binding:"required"`
+	Name       string `json:"name"`
+	Cron       string `json:"cron" binding:"required"` // e.g. "30 5 * * *"
+	DaysOfWeek string `json:"days_of_week"`            // e.g. "mon,wed,fri"
+	Duration   uint   `json:"duration" binding:"required"`
+	Timezone   string `json:"timezone"`   // e.g. "Asia/Karachi"
+	StartDate  string `json:"start_date"` // YYYY-MM-DD
+	EndDate    string `json:"end_date"`   // YYYY-MM-DD
+	Enabled    *bool  `json:"enabled"`    // Defaults to true
+	SkipNext   bool   `json:"skip_next"`
+}
+
+// apply validates the input and copies it onto the schedule
+func (input *ScheduleInput) apply(schedule *models.Schedule) string {
+	if err := database.GetDB().First(&models.Device{}, input.DeviceID).Error; err != nil {
+		return "Device not found"
+	}
+	if input.Duration > maxRunMinutes {
+		return "Duration must be at most 24 hours"
+	}
+	if _, err := services.ParseCron(input.Cron); err != nil {
+		return "Invalid cron expression: " + err.Error()
+	}
+	if _, err := services.ParseDaysOfWeek(input.DaysOfWeek); err != nil {
+		return "Invalid days_of_week: " + err.Error()
+	}
+	if input.Timezone != "" {
+		if _, err := time.LoadLocation(input.Timezone); err != nil {
+			return "Invalid timezone"
+		}
+	}
+	startDate, err := parseDate(input.StartDate)
+	if err != nil {
+		return "Invalid start_date, expected YYYY-MM-DD"
+	}
+	endDate, err := parseDate(input.EndDate)
+	if err != nil {
+		return "Invalid end_date, expected YYYY-MM-DD"
+	}
+	if startDate != nil && endDate != nil && endDate.Before(*startDate) {
+		return "end_date must not be before start_date"
+	}
+
+	schedule.DeviceID = input.DeviceID
+	schedule.Name = input.Name
+	schedule.CronExpr = input.Cron
+	schedule.DaysOfWeek = input.DaysOfWeek
+	schedule.Duration = time.Duration(input.Duration) * time.Minute
+	schedule.Timezone = input.Timezone
+	schedule.StartDate = startDate
+	schedule.EndDate = endDate
+	schedule.Enabled = input.Enabled == nil || *input.Enabled
+	schedule.SkipNext = input.SkipNext
+	schedule.NextRunAt, _ = services.NextScheduleRun(schedule, time.Now())
+	return ""
+}
+
+// parseDate parses an optional YYYY-MM-DD date
+func parseDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
+
+// formatDate formats an optional date as YYYY-MM-DD
+func formatDate(date *time.Time) interface{} {
+	if date == nil {
+		return nil
+	}
+	return date.Format("2006-01-02")
+}
+
+func scheduleResponse(schedule *models.Schedule) gin.H {
+	return gin.H{
+		"id":           schedule.ID,
+		"user_id":      schedule.UserID,
+		"device_id":    schedule.DeviceID,
+		"name":         schedule.Name,
+		"cron":         schedule.CronExpr,
+		"days_of_week": schedule.DaysOfWeek,
+		"duration":     schedule.Duration.Minutes(),
+		"timezone":     schedule.Timezone,
+		"start_date":   formatDate(schedule.StartDate),
+		"end_date":     formatDate(schedule.EndDate),
+		"enabled":      schedule.Enabled,
+		"skip_next":    schedule.SkipNext,
+		"next_run_at":  schedule.NextRunAt,
+		"last_run_at":  schedule.LastRunAt,
+	}
+}
+
+// loadSchedule fetches the schedule in the URL, answering 404 unless the caller owns it or is an admin
+func loadSchedule(c *gin.Context) (*models.Schedule, bool) {
+	id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return nil, false
+	}
+	user := c.MustGet("user").(models.User)
+
+	var schedule models.Schedule
+	if err := database.GetDB().First(&schedule, id64).Error; err != nil ||
+		(schedule.UserID != user.ID && user.Role != models.RoleAdmin) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return nil, false
+	}
+	return &schedule, true
+}
+
+// CreateSchedule creates a recurring activation schedule owned by the current user
+func CreateSchedule(c *gin.Context) {
+	var input ScheduleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	schedule := models.Schedule{UserID: c.GetUint("userID")}
+	if msg := input.apply(&schedule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if err := database.GetDB().Create(&schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule"})
+		return
+	}
+	c.JSON(http.StatusCreated, scheduleResponse(&schedule))
+}
+
+// ListSchedules lists the current user's schedules (all schedules for admins)
+func ListSchedules(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+
+	query := database.GetDB().Order("id")
+	if user.Role != models.RoleAdmin {
+		query = query.Where("user_id = ?", user.ID)
+	}
+	var schedules []models.Schedule
+	if err := query.Find(&schedules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedules"})
+		return
+	}
+
+	response := make([]gin.H, 0, len(schedules))
+	for i := range schedules {
+		response = append(response, scheduleResponse(&schedules[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"schedules": response})
+}
+
+// GetSchedule returns a single schedule
+func GetSchedule(c *gin.Context) {
+	schedule, ok := loadSchedule(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, scheduleResponse(schedule))
+}
+
+// UpdateSchedule replaces a schedule's settings
+func UpdateSchedule(c *gin.Context) {
+	schedule, ok := loadSchedule(c)
+	if !ok {
+		return
+	}
+	var input ScheduleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	if msg := input.apply(schedule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if err := database.GetDB().Save(schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, scheduleResponse(schedule))
+}
+
+// DeleteSchedule removes a schedule; activations it already queued are not affected
+func DeleteSchedule(c *gin.Context) {
+	schedule, ok := loadSchedule(c)
+	if !ok {
+		return
+	}
+	if err := database.GetDB().Delete(schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted"})
+}
+
+// SkipNextScheduleRun skips the next occurrence of a schedule
+func SkipNextScheduleRun(c *gin.Context) {
+	schedule, ok := loadSchedule(c)
+	if !ok {
+		return
+	}
+	if err := database.GetDB().Model(schedule).Update("skip_next", true).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, scheduleResponse(schedule))
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+// SessionHistoryHandler lists device sessions, newest first.
+// Users see their own sessions; admins see everyone's. Optional filters: device_id, limit.
+func SessionHistoryHandler(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+
+	query := database.GetDB().Order("id DESC")
+	if user.Role != models.RoleAdmin {
+		query = query.Where("user_id = ?", user.ID)
+	}
+	if deviceID := c.Query("device_id"); deviceID != "" {
+		query = query.Where("device_id = ?", deviceID)
+	}
+	limit := 50
+	if value, err := strconv.Atoi(c.Query("limit")); err == nil && value > 0 && value <= 500 {
+		limit = value
+	}
+
+	var sessions []models.DeviceSession
+	if err := query.Limit(limit).Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	response := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, gin.H{
+			"id":                session.ID,
+			"device_id":         session.DeviceID,
+			"user_id":           session.UserID,
+			"origin":            session.Origin,
+			"schedule_id":       session.ScheduleID,
+			"intended_duration": session.IntendedDuration,
+			"started_at":        session.StartedAt,
+			"ended_at":          session.EndedAt,
+			"reason":            session.Reason,
+			"stopped_by":        session.StoppedBy,
+			"stop_detail":       session.StopDetail,
+			"energy_kwh":        session.EnergyKWh,
+			"energy_source":     session.EnergySource,
+			"cost":              session.Cost,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": response})
+}
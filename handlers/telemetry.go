@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/services"
+)
+
+const (
+	maxTelemetryRange  = 31 * 24 * time.Hour // Longest window a single request may cover
+	maxTelemetryPoints = 2000                // Upper bound on buckets returned
+	defaultPoints      = 500                 // Buckets used when no resolution is given
+)
+
+// TelemetryHandler returns a device's telemetry as a downsampled series.
+// Query parameters: from, to (RFC3339, default the last 24 hours) and resolution (e.g. "1m", "1h").
+func TelemetryHandler(c *gin.Context) {
+	id64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'to', expected RFC3339"})
+			return
+		}
+	}
+	from := to.Add(-24 * time.Hour)
+	if value := c.Query("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'from', expected RFC3339"})
+			return
+		}
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'from' must be before 'to'"})
+		return
+	}
+	if to.Sub(from) > maxTelemetryRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Time range must not exceed 31 days"})
+		return
+	}
+
+	resolution := (to.Sub(from) / defaultPoints).Truncate(time.Second)
+	if value := c.Query("resolution"); value != "" {
+		if resolution, err = time.ParseDuration(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution, expected a duration such as 1m or 1h"})
+			return
+		}
+	}
+	if resolution < time.Second {
+		resolution = time.Second
+	}
+	if to.Sub(from)/resolution > maxTelemetryPoints {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Resolution too fine for this time range"})
+		return
+	}
+
+	points, err := services.TelemetrySeries(uint(id64), from, to, resolution)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch telemetry"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":  id64,
+		"from":       from,
+		"to":         to,
+		"resolution": resolution.String(),
+		"points":     points,
+	})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"github.com/musabgulfam/pumplink-backend/services"
+)
+
+// UsageCostsHandler returns per-user energy and cost statements for a month, for splitting the electricity bill.
+// Users see their own statement; admins see everyone's. Query: month=YYYY-MM (default this month), device_id, user_id (admins).
+func UsageCostsHandler(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	cfg := services.Settings()
+
+	month := time.Now().In(cfg.Location())
+	if value := c.Query("month"); value != "" {
+		parsed, err := time.Parse("2006-01", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid month, expected YYYY-MM"})
+			return
+		}
+		month = parsed
+	}
+
+	var userID, deviceID uint
+	if user.Role != models.RoleAdmin {
+		userID = user.ID
+	} else if value := c.Query("user_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+			return
+		}
+		userID = uint(id)
+	}
+	if value := c.Query("device_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device_id"})
+			return
+		}
+		deviceID = uint(id)
+	}
+
+	statements, err := services.MonthlyCostStatements(month, userID, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute usage costs"})
+		return
+	}
+
+	var totalSessions int64
+	var totalEnergy, totalCost float64
+	for _, statement := range statements {
+		totalSessions += statement.Sessions
+		totalEnergy += statement.EnergyKWh
+		totalCost += statement.Cost
+	}
+	if statements == nil {
+		statements = []services.CostStatement{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"month":    month.Format("2006-01"),
+		"currency": cfg.TariffCurrency,
+		"tariff": gin.H{
+			"peak_rate":    cfg.TariffPeakRate,
+			"offpeak_rate": cfg.TariffOffPeakRate,
+			"peak_hours":   cfg.TariffPeakHours,
+		},
+		"statements": statements,
+		"totals": gin.H{
+			"sessions":   totalSessions,
+			"energy_kwh": totalEnergy,
+			"cost":       totalCost,
+		},
+	})
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/musabgulfam/pumplink-backend/config"
@@ -31,6 +36,7 @@ func main() {
 	// This reads all our settings like database path, MQTT broker URL, JWT secret, etc.
 	// If environment variables aren't set, it uses sensible defaults
 	cfg := config.Load()
+	services.Configure(cfg)
 	log.Printf("Starting MQTT Motor Backend on port %s", cfg.Port)
 
 	// Step 3: Set Gin mode based on configuration
@@ -51,23 +57,70 @@ func main() {
 	}
 	log.Println("Database connected successfully")
 
+	// Make sure every closed session is reflected in the quota ledger
+	services.SyncQuotaLedger()
+
 	if err := services.Connect(fmt.Sprintf("%s://%s:%d", cfg.MQTTProtocol, cfg.MQTTHost, cfg.MQTTPort)); err != nil {
 		log.Fatal("MQTT connection error: ", err)
 	}
 	log.Println("Connected to MQTT broker successfully")
 
+	// Initialize and start the device service
+	deviceService := deviceService.NewDeviceService()
+
+	// Check running devices against their protection thresholds on every telemetry sample
+	services.OnTelemetry(deviceService.CheckProtection)
+
+	// Confirm OFF commands (and clear faults) from the relay state devices report
+	services.OnTelemetry(deviceService.ObserveRelayState)
+
+	// Stop on SIGINT/SIGTERM (e.g. during a deploy); see Step 10
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Subscribe to all device status topics (encapsulated)
 	services.SubscribeToDeviceStatus()
 
-	// Initialize and start the device service
-	deviceService := deviceService.NewDeviceService()
-	deviceService.StartActivator()
+	// Pass WebSocket events raised on other replicas to this replica's clients
+	services.ListenForBackendEvents(ctx)
+
+	// Only the leader replica drives devices; the others serve HTTP and WebSocket
+	// and forward activations to it through the database
+	scheduler := services.NewScheduler(deviceService)
+	elector := services.NewLeaderElector(cfg.LeaderLockID)
+	go elector.Run(ctx, cfg.LeaderRetryInterval, func() {
+		// Subscribe to acknowledgment topics
+		services.Subscribe(services.MQTTAckTopic, func(client mqtt.Client, msg mqtt.Message) {
+			// Parse deviceID from topic (e.g., device/123/ack) and match the ACK to its command
+			deviceID, ok := services.ParseDeviceTopicID(msg.Topic())
+			if !ok {
+				deviceService.HandleUnknownAck(msg.Topic())
+				return
+			}
+			payload, err := services.VerifyDeviceMessage(deviceID, msg.Topic(), msg.Payload())
+			if err != nil {
+				log.Printf("[Auth] Rejected ACK on %s: %v", msg.Topic(), err)
+				return
+			}
+			services.TouchDevice(deviceID)
+			deviceService.HandleAcknowledgement(deviceID, services.ParseAckPayload(payload))
+		})
+
+		// Recovery waits for devices to confirm OFF, so ACKs and status must be flowing first
+		deviceService.StartActivator()
+
+		// Keep alerting admins about devices that never confirmed OFF
+		services.StartFaultEscalation(ctx)
+
+		// Track device heartbeats and mark silent devices offline
+		services.SubscribeToDeviceHeartbeats()
+		services.StartPresenceMonitor(ctx)
 
-	// Subscribe to acknowledgment topics
-	services.Subscribe(services.MQTTAckTopic, func(client mqtt.Client, msg mqtt.Message) {
-		// Parse deviceID from topic (e.g., device/123/ack)
-		// TODO: Implement HandleAcknowledgement in services package if needed
-		deviceService.HandleAcknowledgement(1)
+		// Let new devices redeem claim codes over MQTT
+		services.SubscribeToProvisioning()
+
+		// Start the scheduler that queues recurring activations
+		scheduler.Start()
 	})
 
 	// Step 5: Initialize the HTTP server using Gin framework
@@ -86,6 +139,7 @@ func main() {
 	{
 		api.POST("/register", handlers.Register)
 		api.POST("/login", handlers.Login)
+		api.POST("/provision", handlers.ProvisionDevice) // Devices redeem a claim code for their ID and secret
 		api.GET("/ws", func(c *gin.Context) {
 			handlers.WebSocketHandler(c.Writer, c.Request)
 		}) // WebSocket endpoint for real-time updates. JWT authentication is done in the WebSocket handler
@@ -105,17 +159,96 @@ func main() {
 			protected.POST("/activate", middleware.RoleMiddleware(models.RoleUser, models.RoleAdmin), handlers.DeviceHandler(deviceService)) // Activate a device with a duration
 
 			protected.GET("device/:id/status", handlers.DeviceStatusHandler)
+			protected.GET("/device/:id/telemetry", handlers.TelemetryHandler)            // Downsampled telemetry for charts
+			protected.GET("/device/:id/state", handlers.DeviceStateHandler)              // Lifecycle state and its transitions
+			protected.GET("/device/:id/maintenance", handlers.MaintenanceHistoryHandler) // Maintenance log
+
+			protected.GET("/activations/:id", handlers.ActivationStatusHandler) // Follow a queued activation request
+			protected.POST("/activations/:id/cancel", middleware.RoleMiddleware(models.RoleUser, models.RoleAdmin), handlers.CancelActivationHandler(deviceService)) // Cancel your own queued or running activation
+
+			protected.GET("/sessions", handlers.SessionHistoryHandler) // Session history, including schedule origin
+			protected.GET("/usage/costs", handlers.UsageCostsHandler)  // Monthly energy and cost per user
+
+			// Recurring activation schedules
+			schedules := protected.Group("/schedules")
+			schedules.Use(middleware.RoleMiddleware(models.RoleUser, models.RoleAdmin))
+			{
+				schedules.POST("", handlers.CreateSchedule)
+				schedules.GET("", handlers.ListSchedules)
+				schedules.GET("/:id", handlers.GetSchedule)
+				schedules.PUT("/:id", handlers.UpdateSchedule)
+				schedules.DELETE("/:id", handlers.DeleteSchedule)
+				schedules.POST("/:id/skip-next", handlers.SkipNextScheduleRun)
+			}
+
+			// Devices: everyone can see live state, admins manage settings
+			devices := protected.Group("/devices")
+			{
+				devices.GET("", handlers.ListDevices)
+				devices.POST("", middleware.RoleMiddleware(models.RoleAdmin), handlers.CreateDevice)
+				devices.GET("/:id", middleware.RoleMiddleware(models.RoleAdmin), handlers.GetDevice)
+				devices.PUT("/:id", middleware.RoleMiddleware(models.RoleAdmin), handlers.UpdateDevice)
+				devices.PATCH("/:id", middleware.RoleMiddleware(models.RoleAdmin), handlers.UpdateDevice)
+				devices.DELETE("/:id", middleware.RoleMiddleware(models.RoleAdmin), handlers.RetireDeviceHandler(deviceService))
+				devices.POST("/:id/claim-code", middleware.RoleMiddleware(models.RoleAdmin), handlers.IssueClaimCode) // New one-time provisioning code
+			}
+
+			// Load-shedding calendar: everyone can see it, admins manage it
+			blackouts := protected.Group("/blackouts")
+			{
+				blackouts.GET("", handlers.ListBlackouts)
+				blackouts.POST("", middleware.RoleMiddleware(models.RoleAdmin), handlers.CreateBlackout)
+				blackouts.PUT("/:id", middleware.RoleMiddleware(models.RoleAdmin), handlers.UpdateBlackout)
+				blackouts.DELETE("/:id", middleware.RoleMiddleware(models.RoleAdmin), handlers.DeleteBlackout)
+			}
 
 			protected.POST("/device/:id/force-shutdown", middleware.RoleMiddleware(models.RoleAdmin), handlers.ForceShutdownHandler(deviceService))
+			protected.POST("/device/:id/fault/acknowledge", middleware.RoleMiddleware(models.RoleAdmin), handlers.AcknowledgeFaultHandler(deviceService)) // Stop fault alerts, optionally clearing the FAULT
+			protected.POST("/device/:id/maintenance", middleware.RoleMiddleware(models.RoleAdmin), handlers.StartMaintenanceHandler(deviceService))       // Take the device out of service
+			protected.DELETE("/device/:id/maintenance", middleware.RoleMiddleware(models.RoleAdmin), handlers.EndMaintenanceHandler(deviceService))       // Return the device to service
+
+			protected.GET("/acks/stats", middleware.RoleMiddleware(models.RoleAdmin), handlers.AckStatsHandler(deviceService)) // Stray, duplicate and unknown ACK counts
+
+			protected.PATCH("/device/:id/activation", middleware.RoleMiddleware(models.RoleUser, models.RoleAdmin), handlers.AdjustActivationHandler(deviceService)) // Extend or shorten a running activation
 
 			protected.POST("/register-push-token", handlers.RegisterPushToken)
 		}
-		// Step 9: Start the HTTP server
-		// This begins listening for incoming HTTP requests on the specified port
-		// The server will run indefinitely until manually stopped or an error occurs
-		if err := r.Run(":" + cfg.Port); err != nil {
+	}
+
+	// Step 9: Start the HTTP server
+	// This begins listening for incoming HTTP requests on the specified port
+	// The server runs until the process receives SIGINT or SIGTERM
+	srv := &http.Server{Addr: ":" + cfg.Port, Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			// If the server fails to start (e.g., port already in use), we log and exit
 			log.Fatal("Failed to start server:", err)
 		}
+	}()
+
+	// Step 10: Shut down gracefully on SIGINT/SIGTERM (e.g. during a deploy)
+	<-ctx.Done()
+	log.Printf("[Shutdown] Signal received, shutting down (policy %q, timeout %v)", cfg.ShutdownPolicy, cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Finish in-flight HTTP requests, then disconnect WebSocket clients (hijacked connections are not drained)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[Shutdown] HTTP server did not drain: %v", err)
+	}
+	services.CloseClients()
+
+	// Stop feeding and draining the queue; queued requests stay for the next instance
+	scheduler.Stop()
+	if err := deviceService.Shutdown(shutdownCtx, cfg.ShutdownPolicy); err != nil {
+		log.Printf("[Shutdown] Activations did not stop in time: %v", err)
 	}
+
+	// Let another replica take over as leader
+	elector.Release()
+
+	// Let the final OFF commands reach the broker before disconnecting
+	services.Disconnect()
+	log.Println("[Shutdown] Done")
 }
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Activation attempt outcomes
+const (
+	AttemptPending       = "pending"        // Waiting for the device ACK
+	AttemptAcked         = "acked"          // The device acknowledged this command
+	AttemptTimedOut      = "timeout"        // No ACK arrived in time
+	AttemptPublishFailed = "publish_failed" // The command could not be published to the broker
+	AttemptCancelled     = "cancelled"      // The activation was stopped while waiting
+)
+
+// ActivationAttempt is one publish of the ON command for an activation request.
+type ActivationAttempt struct {
+	gorm.Model
+	ActivationRequestID uint          `gorm:"not null;index"`
+	Attempt             int           `gorm:"not null"` // 1 for the first publish
+	CommandID           string        // ID of the published command
+	SentAt              time.Time     // When the command was published
+	Timeout             time.Duration // How long the ACK was waited for
+	Outcome             string        `gorm:"type:text;not null;default:'pending'"`
+	Error               string        // Publish error, if any
+	AckedAt             *time.Time    // When the ACK arrived
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Activation request statuses
+const (
+	ActivationQueued      = "queued"      // Waiting for the activator
+	ActivationDispatching = "dispatching" // ON command published, waiting for the device ACK
+	ActivationRunning     = "running"     // Device is ON and a session is open
+	ActivationCompleted   = "completed"   // Session ended and the device was turned OFF
+	ActivationRejected    = "rejected"    // Could not be started (quota, device state, missing ACK, ...)
+	ActivationCancelled   = "cancelled"   // Withdrawn or stopped before it could complete
+)
+
+// ActivationRequest is a durable entry in the device activation queue.
+type ActivationRequest struct {
+	gorm.Model
+	UserID       uint                `gorm:"not null;index"` // User who requested the activation
+	User         User                `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	DeviceID     uint                `gorm:"not null;index"` // Device to activate
+	Device       Device              `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Duration     time.Duration       `gorm:"not null"`                            // Requested run time
+	Origin       string              `gorm:"type:text;not null;default:'manual'"` // manual or schedule
+	ScheduleID   *uint               `gorm:"index"`                               // Schedule that queued the request, if any
+	Status       string              `gorm:"type:text;not null;index;check:status IN ('queued','dispatching','running','completed','rejected','cancelled');default:'queued'"`
+	StatusReason string              // Why the request ended up in its current status
+	NotBefore    *time.Time          // A held request is not dispatched again before this time
+	SessionID    *uint               // Session created once the device acknowledged the ON command
+	StartedAt    *time.Time          // When the device was turned ON
+	FinishedAt   *time.Time          // When the request reached a final status
+	Attempts     []ActivationAttempt `gorm:"foreignKey:ActivationRequestID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"` // ON command publishes, in order
+}
+
+// IsFinal reports whether the request can no longer change status.
+func (r *ActivationRequest) IsFinal() bool {
+	switch r.Status {
+	case ActivationCompleted, ActivationRejected, ActivationCancelled:
+		return true
+	}
+	return false
+}
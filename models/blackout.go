@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Blackout is a scheduled power outage during which devices must not run.
+// A blackout is either weekly (DaysOfWeek, StartTime and EndTime in site time)
+// or a one-off window (StartsAt and EndsAt).
+type Blackout struct {
+	gorm.Model
+	Name       string     // e.g. "Feeder 4 load-shedding"
+	DeviceID   *uint      `gorm:"index"` // Affected device (nil means every device)
+	DaysOfWeek string     // Weekly slot days, e.g. "mon,wed,fri" (empty for a one-off blackout)
+	StartTime  string     // Weekly slot start, "HH:MM" in site time
+	EndTime    string     // Weekly slot end, "HH:MM"; before StartTime for slots that cross midnight
+	StartsAt   *time.Time // One-off blackout start
+	EndsAt     *time.Time // One-off blackout end
+	Enabled    bool       `gorm:"not null"`
+	CreatedBy  uint       // Admin who created the blackout
+}
+
+// Weekly reports whether the blackout repeats every week.
+func (b *Blackout) Weekly() bool {
+	return b.DaysOfWeek != ""
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Commands a follower replica forwards to the leader
+const (
+	ControlStop       = "stop"       // Stop the device's activation
+	ControlReschedule = "reschedule" // Move the running session's end time
+)
+
+// ControlCommand asks the leader replica to act on an activation it is running.
+// Followers write them; the leader polls for unprocessed commands and applies them.
+type ControlCommand struct {
+	gorm.Model
+	Command             string     `gorm:"type:text;not null"`
+	DeviceID            uint       `gorm:"not null;index"`
+	ActivationRequestID *uint      // Only stop this request (nil stops whatever is running)
+	SessionID           *uint      // Session whose end time moves (reschedule)
+	Reason              string     // Stop reason, e.g. "force"
+	UserID              uint       // User who asked for it (0 for the system)
+	Detail              string     // Stop detail
+	Deadline            *time.Time // New end time (reschedule)
+	ProcessedAt         *time.Time `gorm:"index"` // nil until the leader has handled it
+	Outcome             string     // "applied", or why it was not
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// dateLayout is how a Date is written.
+const dateLayout = "2006-01-02"
+
+// Date is a calendar day, e.g. "2026-10-16", stored in a DATE column. It is sent to the
+// database as text, so neither the connection's TimeZone nor the server can shift it.
+type Date string
+
+// DateOf returns the calendar day of t in the given location.
+func DateOf(t time.Time, loc *time.Location) Date {
+	return Date(t.In(loc).Format(dateLayout))
+}
+
+// Value implements driver.Valuer.
+func (d Date) Value() (driver.Value, error) {
+	return string(d), nil
+}
+
+// Scan implements sql.Scanner. DATE columns come back as a midnight time.Time, whose
+// own calendar day is the stored one, or as text.
+func (d *Date) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case time.Time:
+		*d = Date(v.Format(dateLayout))
+	case string:
+		return d.parse(v)
+	case []byte:
+		return d.parse(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into Date", value)
+	}
+	return nil
+}
+
+func (d *Date) parse(s string) error {
+	if len(s) > len(dateLayout) {
+		s = s[:len(dateLayout)]
+	}
+	if _, err := time.Parse(dateLayout, s); err != nil {
+		return err
+	}
+	*d = Date(s)
+	return nil
+}
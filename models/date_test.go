@@ -0,0 +1,51 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateRoundTrip(t *testing.T) {
+	karachi := time.FixedZone("PKT", 5*60*60)
+	day := DateOf(time.Date(2026, 10, 16, 23, 30, 0, 0, karachi), karachi)
+	if day != "2026-10-16" {
+		t.Fatalf("DateOf = %q, want 2026-10-16", day)
+	}
+
+	stored, err := day.Value()
+	if err != nil || stored != "2026-10-16" {
+		t.Fatalf("Value = %v, %v; want the text 2026-10-16", stored, err)
+	}
+
+	tests := []struct {
+		name string
+		read interface{}
+	}{
+		{"DATE as UTC midnight", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{"DATE as local midnight", time.Date(2026, 10, 16, 0, 0, 0, 0, karachi)},
+		{"DATE as midnight west of UTC", time.Date(2026, 10, 16, 0, 0, 0, 0, time.FixedZone("EDT", -4*60*60))},
+		{"text", "2026-10-16"},
+		{"bytes", []byte("2026-10-16")},
+		{"timestamp text", "2026-10-16T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Date
+			if err := got.Scan(tt.read); err != nil {
+				t.Fatalf("Scan(%v): %v", tt.read, err)
+			}
+			if got != day {
+				t.Errorf("Scan(%v) = %q, want %q", tt.read, got, day)
+			}
+		})
+	}
+}
+
+func TestDateScanRejectsGarbage(t *testing.T) {
+	var d Date
+	for _, value := range []interface{}{"yesterday", 20261016, nil} {
+		if err := d.Scan(value); err == nil {
+			t.Errorf("Scan(%v) = %q, want an error", value, d)
+		}
+	}
+}
@@ -1,12 +1,62 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// What a request does when the device cannot start yet
+const (
+	PolicyQueue  = "queue"  // Hold the request in the queue until the device may start
+	PolicyReject = "reject" // Reject the request straight away
+)
+
+// Device lifecycle states
+const (
+	StateOffline     = "OFFLINE"     // Idle and not heard from recently
+	StateIdle        = "IDLE"        // OFF and ready to start
+	StateStarting    = "STARTING"    // ON command sent, waiting for the device to acknowledge
+	StateRunning     = "RUNNING"     // ON with an open session
+	StateStopping    = "STOPPING"    // OFF command sent, waiting for the device to confirm
+	StateFault       = "FAULT"       // The device did not confirm OFF; needs an admin
+	StateMaintenance = "MAINTENANCE" // Taken out of service by an admin
+)
+
 type Device struct {
 	gorm.Model
-	Name           string          `gorm:"not null"`
-	State          string          `gorm:"type:text; check:state IN ('ON','OFF','UNKNOWN'); default:'UNKNOWN'"`
+	Name                 string        `gorm:"not null"`
+	State                string        `gorm:"type:text; check:state IN ('OFFLINE','IDLE','STARTING','RUNNING','STOPPING','FAULT','MAINTENANCE'); default:'OFFLINE'"`
+	ControlTopic         string        `gorm:"type:text"`              // Control topic template, e.g. "device/{id}/control" (empty uses the default template)
+	LegacyControl        bool          `gorm:"not null;default:false"` // Publish to the shared "device/control" topic for single-topic firmware
+	LastSeenAt           *time.Time    // Last status, ACK or heartbeat message from the device
+	Online               bool          `gorm:"not null;default:false"` // Whether the device has been heard from recently
+	RatedPowerKW         *float64      // Rated motor power in kW
+	MinRunTime           time.Duration // Shortest single activation allowed (0 = no limit)
+	MaxRunTime           time.Duration // Longest single activation allowed (0 = no limit)
+	MaxContinuousRuntime time.Duration // Longest run across back-to-back sessions (0 = no limit)
+	AckTimeout           time.Duration // How long to wait for the first ON ACK (0 uses the default); later attempts wait longer
+
+	// Provisioning: a device redeems a one-time claim code for its own secret, then signs its messages
+	ClaimCodeHash      string     `gorm:"type:text;index" json:"-"` // SHA-256 of the outstanding claim code
+	ClaimCodeExpiresAt *time.Time // When the outstanding claim code stops working
+	Secret             string     `gorm:"type:text" json:"-"` // Per-device HMAC key; empty until claimed (messages are then not verified)
+	ClaimedAt          *time.Time // When the device last redeemed a claim code
+
+	// Protection thresholds checked against telemetry while the device runs (nil disables a check)
+	MinFlowRate     *float64      // Trip if flow (L/min) is below this once the grace period has passed (dry run)
+	FlowGracePeriod time.Duration // Time after start before the minimum flow applies
+	MaxCurrent      *float64      // Trip if current (A) exceeds this (overload)
+	MinVoltage      *float64      // Trip if voltage (V) drops below this (brown-out)
+
+	// Interlock: devices sharing a non-empty group never run at the same time
+	InterlockGroup  string `gorm:"type:text;index"`                                                          // e.g. "transformer-1"
+	InterlockPolicy string `gorm:"type:text; check:interlock_policy IN ('queue','reject'); default:'queue'"` // What a conflicting request does
+
+	// Motor protection: limit how soon and how often the device restarts (0 disables a limit)
+	MinOffTime       time.Duration // Minimum rest between the end of one session and the next start
+	MaxStartsPerHour int           // Maximum number of starts in any rolling hour
+	CooldownPolicy   string        `gorm:"type:text; check:cooldown_policy IN ('queue','reject'); default:'queue'"` // What a request inside the cooldown does
+
 	DeviceSessions []DeviceSession `gorm:"foreignKey:DeviceID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE; nullable:true"`
 }
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeviceFault records a device that did not confirm it turned OFF.
+// Admins are alerted repeatedly until someone acknowledges it.
+type DeviceFault struct {
+	gorm.Model
+	DeviceID       uint       `gorm:"not null;index"`
+	Device         Device     `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	SessionID      *uint      // Session whose shutdown failed, if any
+	Detail         string     // What went wrong, e.g. "no OFF confirmation after 4 attempts"
+	LastAlertAt    time.Time  // When admins were last alerted
+	AlertCount     int        `gorm:"not null"`
+	AcknowledgedBy *uint      // Admin who acknowledged the fault
+	AcknowledgedAt *time.Time // Alerts stop once set
+	ResolvedAt     *time.Time // When the device was confirmed OFF again
+	Resolution     string     // How the fault was resolved
+}
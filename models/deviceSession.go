@@ -6,6 +6,18 @@ import (
 	"gorm.io/gorm"
 )
 
+// Session end reasons
+const (
+	ReasonCompleted     = "completed"       // Ran for the full duration
+	ReasonForce         = "force"           // Stopped by an admin
+	ReasonUserCancelled = "user_cancelled"  // Stopped by the user who requested it
+	ReasonRecovered     = "recovered"       // Closed (or resumed and finished) by startup reconciliation
+	ReasonProtection    = "protection_trip" // Stopped by dry-run or overload protection
+	ReasonBlackout      = "blackout"        // Stopped ahead of a scheduled power outage
+	ReasonMaintenance   = "maintenance"     // Stopped because the device was put under maintenance
+	ReasonShutdown      = "shutdown"        // Stopped because the backend shut down
+)
+
 type DeviceSession struct {
 	gorm.Model                    // Includes ID, CreatedAt, UpdatedAt, DeletedAt
 	UserID           uint         `gorm:"not null"` // Foreign key for User
@@ -15,5 +27,15 @@ type DeviceSession struct {
 	DeviceLogs       *[]DeviceLog `gorm:"foreignKey:SessionID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;nullable:true; optional:true"`
 	IntendedDuration string       // Intended duration in seconds for which the device should remain active
 	ActiveUntil      time.Time    // Time until which the device is active
+	StartedAt        time.Time    // Time the device acknowledged the ON command
+	EndedAt          *time.Time   // Time the device was turned OFF (nil while the session is open)
 	Reason           string       // Reason for the session
+	StoppedBy        *uint        // User who stopped the session early, if any
+	StopDetail       string       // Details of an automatic stop, e.g. which protection threshold tripped
+	Origin           string       `gorm:"type:text;not null;default:'manual'"` // manual or schedule
+	ScheduleID       *uint        // Schedule that started the session, if any
+	EnergyKWh        *float64     `gorm:"column:energy_kwh"` // Energy used, set when the session closes
+	EnergySource     string       `gorm:"type:text"`         // "telemetry" (measured) or "rated" (rated kW x runtime)
+	Cost             *float64     // Price of the energy under the tariff in force when the session closed
+	HandedOffAt      *time.Time   // Set when a shutting-down backend left the session running for the next instance
 }
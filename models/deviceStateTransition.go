@@ -0,0 +1,33 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Why a device changed state, besides the session stop reasons (completed, force, ...)
+// used for RUNNING -> STOPPING
+const (
+	CauseActivation      = "activation"       // An activation request started the device
+	CauseAcknowledged    = "acknowledged"     // The device acknowledged the ON command
+	CauseStartAborted    = "start_aborted"    // The start was cancelled or never acknowledged
+	CauseOffConfirmed    = "off_confirmed"    // The device confirmed the OFF command
+	CauseOffUnconfirmed  = "off_unconfirmed"  // The device never confirmed the OFF command
+	CauseRelayOpen       = "relay_open"       // Telemetry reported the relay open while in FAULT
+	CauseFaultCleared    = "fault_cleared"    // An admin confirmed the device is OFF
+	CauseWentOffline     = "went_offline"     // The device stopped reporting
+	CauseCameOnline      = "came_online"      // The device was heard from again
+	CauseRecoveryCleanup = "recovery_cleanup" // Found busy without a session after a restart
+	CauseMaintenance     = "maintenance"      // An admin started or ended maintenance
+)
+
+// DeviceStateTransition records one change of a device's lifecycle state.
+type DeviceStateTransition struct {
+	gorm.Model
+	DeviceID  uint   `gorm:"not null;index"`
+	FromState string `gorm:"type:text;not null"`
+	ToState   string `gorm:"type:text;not null"`
+	Cause     string `gorm:"type:text;not null"` // One of the Cause constants or a session stop reason
+	ActorID   *uint  // User who caused the change (nil for the system)
+	SessionID *uint  // Session the change belongs to, if any
+	Detail    string // Extra context, e.g. the fault or stop detail
+}
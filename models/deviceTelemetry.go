@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeviceTelemetry is one validated status sample reported by a device.
+// Readings the device did not report are left NULL.
+type DeviceTelemetry struct {
+	gorm.Model
+	DeviceID      uint      `gorm:"not null;index:idx_device_telemetry_device_time"`
+	RecordedAt    time.Time `gorm:"not null;index:idx_device_telemetry_device_time"` // Device timestamp, or receive time if the device sent none
+	SchemaVersion int       `gorm:"not null"`                                        // Version of the status payload schema
+	Current       *float64  // Motor current in amperes
+	Voltage       *float64  // Supply voltage in volts
+	FlowRate      *float64  // Water flow in litres per minute
+	Pressure      *float64  // Line pressure in bar
+	RelayOn       *bool     // Relay state as reported by the device
+	RSSI          *int      // Wi-Fi/cellular signal strength in dBm
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaintenanceWindow records a device being taken out of service for maintenance.
+// While a window is open the device cannot be started remotely.
+type MaintenanceWindow struct {
+	gorm.Model
+	DeviceID  uint       `gorm:"not null;index"`
+	Device    Device     `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Note      string     // What is being done, e.g. "replacing impeller"
+	StartedBy uint       `gorm:"not null"` // Admin who started maintenance
+	StartedAt time.Time  `gorm:"not null"`
+	EndedBy   *uint      // Admin who ended maintenance
+	EndedAt   *time.Time // nil while the device is under maintenance
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// QuotaUsage is a quota ledger entry: the run time a session used on one local calendar day.
+// Sessions that cross midnight produce one entry per day.
+type QuotaUsage struct {
+	gorm.Model
+	SessionID uint          `gorm:"not null;uniqueIndex:idx_quota_usage_session_day"`                                                                           // Session the usage came from
+	UserID    uint          `gorm:"not null;index:idx_quota_usage_user_day"`                                                                                    // User charged for the usage
+	DeviceID  uint          `gorm:"not null;index:idx_quota_usage_device_day"`                                                                                  // Device that ran
+	Day       Date          `gorm:"type:date;not null;uniqueIndex:idx_quota_usage_session_day;index:idx_quota_usage_user_day;index:idx_quota_usage_device_day"` // Local day in the site timezone
+	Used      time.Duration `gorm:"not null"`                                                                                                                   // Run time on that day
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Activation origins
+const (
+	OriginManual   = "manual"   // Requested through POST /activate
+	OriginSchedule = "schedule" // Queued by the scheduler
+)
+
+// Schedule is a recurring activation of a device.
+type Schedule struct {
+	gorm.Model
+	UserID     uint          `gorm:"not null;index"` // Owner; scheduled runs are charged to this user's quota
+	User       User          `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	DeviceID   uint          `gorm:"not null;index"` // Device to activate
+	Device     Device        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Name       string        // Human-readable label, e.g. "Morning irrigation"
+	CronExpr   string        `gorm:"not null"` // Five-field cron expression, e.g. "30 5 * * *"
+	DaysOfWeek string        // Optional weekday filter, e.g. "mon,wed,fri" (empty means every day)
+	Duration   time.Duration `gorm:"not null"` // Run time of each activation
+	Timezone   string        // IANA timezone the cron expression is evaluated in (empty uses the site timezone)
+	StartDate  *time.Time    `gorm:"type:date"` // First day the schedule may run (inclusive)
+	EndDate    *time.Time    `gorm:"type:date"` // Last day the schedule may run (inclusive)
+	Enabled    bool          `gorm:"not null"`
+	SkipNext   bool          `gorm:"not null"` // Skip the next occurrence once, then clear the flag
+	NextRunAt  *time.Time    `gorm:"index"`    // Next occurrence (nil when the schedule has no more runs)
+	LastRunAt  *time.Time    // Last time a run was queued
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+// issuedCommandTTL is how long an issued command is remembered for ACK correlation.
+const issuedCommandTTL = time.Hour
+
+// ControlCommand is the payload published on a device's control topic.
+type ControlCommand struct {
+	Command   string `json:"command"`    // "on" or "off"
+	CommandID string `json:"command_id"` // Echoed back by the device in its ACK
+}
+
+// AckStats counts ACKs that could not be matched to an awaited command.
+type AckStats struct {
+	Stray     uint64 `json:"stray"`     // ACK for a command that is no longer (or was never) awaited on that device
+	Duplicate uint64 `json:"duplicate"` // ACK for a command that was already acknowledged
+	Unknown   uint64 `json:"unknown"`   // ACK with a command ID this backend never issued
+}
+
+// pendingAck holds the commands the activator is currently waiting on. Every attempt of
+// the same activation is accepted, so a late ACK for an earlier publish still counts.
+type pendingAck struct {
+	commandIDs map[string]bool
+	legacy     bool        // The device runs legacy firmware, which sends ACKs without command IDs
+	ch         chan string // Receives the acknowledged command ID ("" for legacy ACKs)
+}
+
+// issuedCommand remembers a published command so late and duplicate ACKs can be classified.
+type issuedCommand struct {
+	deviceID uint
+	command  string
+	issuedAt time.Time
+	acked    bool
+}
+
+// ackCounters holds the counters behind AckStats.
+type ackCounters struct {
+	stray     atomic.Uint64
+	duplicate atomic.Uint64
+	unknown   atomic.Uint64
+}
+
+// newCommandID returns a random identifier for a control command.
+func newCommandID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return hex.EncodeToString([]byte(time.Now().Format("150405.000000")))
+	}
+	return hex.EncodeToString(b)
+}
+
+// ParseAckPayload extracts the command ID from an ACK payload.
+// Devices send either {"command_id": "..."} or the bare command ID.
+// Legacy firmware sends no command ID at all, in which case "" is returned.
+func ParseAckPayload(payload []byte) string {
+	var ack struct {
+		CommandID string `json:"command_id"`
+	}
+	if err := json.Unmarshal(payload, &ack); err == nil {
+		return ack.CommandID
+	}
+	id := strings.TrimSpace(string(payload))
+	switch strings.ToLower(id) {
+	case "", "ack", "ok", "on", "off":
+		return ""
+	}
+	return id
+}
+
+// publishCommand publishes a control command to the device and remembers it for ACK correlation.
+// Legacy devices receive the bare command string their firmware understands.
+func (ds *DeviceService) publishCommand(device *models.Device, command string) (string, error) {
+	commandID := newCommandID()
+
+	ds.acknowledgmentChannelsMu.Lock()
+	now := time.Now()
+	for id, issued := range ds.issuedCommands {
+		if now.Sub(issued.issuedAt) > issuedCommandTTL {
+			delete(ds.issuedCommands, id)
+		}
+	}
+	ds.issuedCommands[commandID] = &issuedCommand{deviceID: device.ID, command: command, issuedAt: now}
+	ds.acknowledgmentChannelsMu.Unlock()
+
+	var payload interface{} = command
+	if !device.LegacyControl {
+		body, err := json.Marshal(ControlCommand{Command: command, CommandID: commandID})
+		if err != nil {
+			return "", err
+		}
+		payload = body
+	}
+	log.Printf("[MQTT] Publishing %q (command %s) to device %d", command, commandID, device.ID)
+	return commandID, Publish(DeviceControlTopic(device), payload, 2, true)
+}
+
+// expectAck starts waiting for ACKs from the device and returns the pending entry.
+func (ds *DeviceService) expectAck(device *models.Device) *pendingAck {
+	pending := &pendingAck{commandIDs: make(map[string]bool), legacy: device.LegacyControl, ch: make(chan string, 1)}
+	ds.acknowledgmentChannelsMu.Lock()
+	ds.acknowledgmentChannels[device.ID] = pending
+	ds.acknowledgmentChannelsMu.Unlock()
+	return pending
+}
+
+// awaitCommand adds a published command to the ones a pending entry accepts.
+func (ds *DeviceService) awaitCommand(pending *pendingAck, commandID string) {
+	ds.acknowledgmentChannelsMu.Lock()
+	pending.commandIDs[commandID] = true
+	ds.acknowledgmentChannelsMu.Unlock()
+}
+
+// clearAck stops waiting on the pending entry.
+func (ds *DeviceService) clearAck(deviceID uint, pending *pendingAck) {
+	ds.acknowledgmentChannelsMu.Lock()
+	if ds.acknowledgmentChannels[deviceID] == pending {
+		delete(ds.acknowledgmentChannels, deviceID)
+	}
+	ds.acknowledgmentChannelsMu.Unlock()
+}
+
+// HandleAcknowledgement is called when an ACK is received from a device.
+// Only the ACK carrying the awaited command ID confirms a command; everything else is counted and logged.
+// ACKs without a command ID are accepted only from legacy devices, whose firmware cannot echo one.
+func (ds *DeviceService) HandleAcknowledgement(deviceID uint, commandID string) {
+	ds.acknowledgmentChannelsMu.Lock()
+	defer ds.acknowledgmentChannelsMu.Unlock()
+
+	pending, waiting := ds.acknowledgmentChannels[deviceID]
+
+	if commandID == "" {
+		// Legacy firmware does not echo command IDs; accept the ACK for whatever is pending.
+		if waiting && pending.legacy {
+			ds.confirmAck(deviceID, pending, "")
+			return
+		}
+		ds.ackCounters.stray.Add(1)
+		if waiting {
+			log.Printf("[ACK] Stray ACK without command ID from device %d (its firmware echoes command IDs)", deviceID)
+		} else {
+			log.Printf("[ACK] Stray ACK without command ID from device %d (nothing pending)", deviceID)
+		}
+		return
+	}
+
+	issued, known := ds.issuedCommands[commandID]
+	switch {
+	case !known:
+		ds.ackCounters.unknown.Add(1)
+		log.Printf("[ACK] Unknown command %s acknowledged by device %d", commandID, deviceID)
+	case issued.deviceID != deviceID:
+		ds.ackCounters.stray.Add(1)
+		log.Printf("[ACK] Stray ACK: device %d acknowledged command %s issued to device %d", deviceID, commandID, issued.deviceID)
+	case issued.acked:
+		ds.ackCounters.duplicate.Add(1)
+		log.Printf("[ACK] Duplicate ACK for command %s from device %d", commandID, deviceID)
+	case waiting && pending.commandIDs[commandID]:
+		issued.acked = true
+		ds.confirmAck(deviceID, pending, commandID)
+	case issued.command == "off":
+		// OFF commands are fire-and-forget; record the ACK so repeats count as duplicates.
+		issued.acked = true
+		log.Printf("[ACK] OFF command %s acknowledged by device %d", commandID, deviceID)
+	default:
+		issued.acked = true
+		ds.ackCounters.stray.Add(1)
+		log.Printf("[ACK] Stray ACK: command %s from device %d is no longer awaited", commandID, deviceID)
+	}
+}
+
+// confirmAck signals the waiting activator. Callers must hold acknowledgmentChannelsMu.
+func (ds *DeviceService) confirmAck(deviceID uint, pending *pendingAck, commandID string) {
+	delete(ds.acknowledgmentChannels, deviceID)
+	select {
+	case pending.ch <- commandID:
+	default:
+	}
+}
+
+// HandleUnknownAck records an ACK that could not be attributed to any device.
+func (ds *DeviceService) HandleUnknownAck(topic string) {
+	ds.ackCounters.unknown.Add(1)
+	log.Printf("[ACK] Ignoring ACK on unrecognised topic %s", topic)
+}
+
+// AckStats returns the stray, duplicate and unknown ACK counts since startup.
+func (ds *DeviceService) AckStats() AckStats {
+	return AckStats{
+		Stray:     ds.ackCounters.stray.Load(),
+		Duplicate: ds.ackCounters.duplicate.Load(),
+		Unknown:   ds.ackCounters.unknown.Load(),
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+func TestAckWithoutCommandID(t *testing.T) {
+	tests := []struct {
+		name      string
+		legacy    bool
+		wantAcked bool
+	}{
+		{"legacy firmware", true, true},
+		{"firmware that echoes command IDs", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDeviceService()
+			device := &models.Device{LegacyControl: tt.legacy}
+			device.ID = 1
+			pending := ds.expectAck(device)
+			ds.awaitCommand(pending, "abc123")
+
+			ds.HandleAcknowledgement(device.ID, "")
+
+			acked := len(pending.ch) == 1
+			if acked != tt.wantAcked {
+				t.Errorf("bare ACK confirmed the command = %t, want %t", acked, tt.wantAcked)
+			}
+			if stray := ds.AckStats().Stray; (stray == 1) == tt.wantAcked {
+				t.Errorf("stray ACKs = %d", stray)
+			}
+		})
+	}
+}
+
+func TestParseAckPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{"JSON", `{"command_id": "9f2c01ab"}`, "9f2c01ab"},
+		{"JSON without command ID", `{"status": "ok"}`, ""},
+		{"bare command ID", "9f2c01ab", "9f2c01ab"},
+		{"bare command ID with newline", "9f2c01ab\n", "9f2c01ab"},
+		{"legacy ack", "ack", ""},
+		{"legacy ACK in capitals", "ACK", ""},
+		{"legacy ok", "ok", ""},
+		{"legacy command echo", "on", ""},
+		{"empty", "", ""},
+		{"blank", "  ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseAckPayload([]byte(tt.payload)); got != tt.want {
+				t.Errorf("ParseAckPayload(%q) = %q, want %q", tt.payload, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+var ErrNoRunningActivation = errors.New("no running activation for this device")
+var ErrNotSessionOwner = errors.New("activation belongs to another user")
+var ErrSessionEnded = errors.New("session ended before it could be adjusted")
+
+// AdjustActivation changes the remaining run time of a device's running session.
+// Only the session owner (or an admin) may adjust it. A session cannot be shortened below the device's minimum
+// run time, and extensions are checked against the owner's quota, the device's run time limits and the blackout calendar.
+// It returns the session ID and the new end time.
+func (ds *DeviceService) AdjustActivation(deviceID, userID uint, isAdmin bool, remaining time.Duration) (uint, time.Time, error) {
+	active, exists := ds.runningActivation(deviceID)
+	if !exists {
+		return 0, time.Time{}, ErrNoRunningActivation
+	}
+	sessionID, ownerID, startedAt, deadline, device := active.sessionID, active.ownerID, active.startedAt, active.deadline, active.device
+	if ownerID != userID && !isAdmin {
+		return 0, time.Time{}, ErrNotSessionOwner
+	}
+
+	newDeadline := time.Now().Add(remaining)
+
+	// Shortening must leave the motor its minimum run time; an extension must respect the device's other run time limits
+	if newDeadline.Before(deadline) && device.MinRunTime > 0 && newDeadline.Sub(startedAt) < device.MinRunTime {
+		return 0, time.Time{}, &LimitError{DeviceID: deviceID, Limit: LimitMinRunTime, Allowed: device.MinRunTime, Requested: newDeadline.Sub(startedAt)}
+	}
+	if newDeadline.After(deadline) {
+		if device.MaxRunTime > 0 && newDeadline.Sub(startedAt) > device.MaxRunTime {
+			return 0, time.Time{}, &LimitError{DeviceID: deviceID, Limit: LimitMaxRunTime, Allowed: device.MaxRunTime, Requested: newDeadline.Sub(startedAt)}
+		}
+		if limit := checkContinuousRuntime(&device, startedAt, newDeadline.Sub(startedAt), sessionID); limit != nil {
+			return 0, time.Time{}, limit
+		}
+		if blackout := checkBlackout(deviceID, deadline, newDeadline.Sub(deadline)); blackout != nil {
+			return 0, time.Time{}, blackout
+		}
+	}
+
+	// Hold the quota lock so the extension cannot race another lane's reservation
+	ds.quotaMu.Lock()
+	if extra := newDeadline.Sub(deadline); extra > 0 {
+		if err := ds.checkQuotaLocked(ownerID, deviceID, extra); err != nil {
+			ds.quotaMu.Unlock()
+			return 0, time.Time{}, err
+		}
+	}
+	// A session that closed meanwhile keeps the end time its ledger, energy and cost were based on
+	var session models.DeviceSession
+	err := database.GetDB().First(&session, sessionID).Error
+	if err == nil {
+		result := database.GetDB().Model(&session).Where("ended_at IS NULL").Updates(map[string]interface{}{
+			"ActiveUntil":      newDeadline,
+			"IntendedDuration": newDeadline.Sub(session.StartedAt).Round(time.Second).String(),
+		})
+		err = result.Error
+		if err == nil && result.RowsAffected == 0 {
+			err = ErrSessionEnded
+		}
+	}
+	ds.quotaMu.Unlock()
+	if err == ErrSessionEnded {
+		return 0, time.Time{}, err
+	}
+	if err != nil {
+		log.Printf("[DB] Failed to update session %d end time: %v", sessionID, err)
+		return 0, time.Time{}, err
+	}
+
+	// Hand the new deadline to the lane, unless the session ended meanwhile
+	ds.rescheduleActivation(deviceID, sessionID, newDeadline)
+
+	log.Printf("[State] Session %d on device %d adjusted by User %d, now ends at %s", sessionID, deviceID, userID, newDeadline.Format(time.RFC3339))
+
+	BroadcastEvent(map[string]interface{}{
+		"type":         "activation_updated",
+		"device_id":    deviceID,
+		"session_id":   sessionID,
+		"active_until": newDeadline,
+	})
+	SendDevicePushNotificationToAll(
+		deviceID,
+		fmt.Sprintf("Device %d will now stay ON until %s.", deviceID, newDeadline.Format("03:04 PM")),
+		map[string]string{
+			"device_id":    fmt.Sprintf("%d", deviceID),
+			"action":       "adjusted",
+			"active_until": newDeadline.Format(time.RFC3339),
+		},
+	)
+	return sessionID, newDeadline, nil
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+// blackoutHorizon is how far ahead a free slot is searched for.
+const blackoutHorizon = 14 * 24 * time.Hour
+
+// BlackoutWindow is one occurrence of a blackout.
+type BlackoutWindow struct {
+	BlackoutID uint
+	Name       string
+	Start      time.Time
+	End        time.Time
+}
+
+// BlackoutError reports that an activation would overlap a blackout.
+type BlackoutError struct {
+	DeviceID uint
+	Window   BlackoutWindow
+	NextSlot *time.Time // Earliest start that fits (nil if none within the search horizon)
+}
+
+func (e *BlackoutError) Error() string {
+	msg := fmt.Sprintf("device %d would run into blackout %q (%s - %s)", e.DeviceID, e.Window.Name,
+		e.Window.Start.Format("Jan 2 03:04 PM"), e.Window.End.Format("Jan 2 03:04 PM"))
+	if e.NextSlot != nil {
+		msg += fmt.Sprintf(", next available start %s", e.NextSlot.Format("Jan 2 03:04 PM"))
+	}
+	return msg
+}
+
+// parseClock parses a "HH:MM" time of day into an offset from midnight.
+func parseClock(value string) (time.Duration, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+// ValidateBlackout checks that a blackout describes either a weekly slot or a one-off window.
+func ValidateBlackout(blackout *models.Blackout) error {
+	if blackout.Weekly() {
+		if _, err := ParseDaysOfWeek(blackout.DaysOfWeek); err != nil {
+			return err
+		}
+		start, err := parseClock(blackout.StartTime)
+		if err != nil {
+			return err
+		}
+		end, err := parseClock(blackout.EndTime)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return fmt.Errorf("start_time and end_time must differ")
+		}
+		return nil
+	}
+	if blackout.StartsAt == nil || blackout.EndsAt == nil {
+		return fmt.Errorf("a one-off blackout needs starts_at and ends_at")
+	}
+	if !blackout.EndsAt.After(*blackout.StartsAt) {
+		return fmt.Errorf("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// blackoutOccurrences expands a blackout into the windows that overlap [from, to), in site time.
+func blackoutOccurrences(blackout *models.Blackout, from, to time.Time, loc *time.Location) []BlackoutWindow {
+	if !blackout.Weekly() {
+		if blackout.StartsAt == nil || blackout.EndsAt == nil || !blackout.StartsAt.Before(to) || !blackout.EndsAt.After(from) {
+			return nil
+		}
+		return []BlackoutWindow{{BlackoutID: blackout.ID, Name: blackout.Name, Start: blackout.StartsAt.In(loc), End: blackout.EndsAt.In(loc)}}
+	}
+
+	days, err := ParseDaysOfWeek(blackout.DaysOfWeek)
+	if err != nil {
+		return nil
+	}
+	start, errStart := parseClock(blackout.StartTime)
+	end, errEnd := parseClock(blackout.EndTime)
+	if errStart != nil || errEnd != nil {
+		return nil
+	}
+
+	var windows []BlackoutWindow
+	// Start a day early to catch a slot that began the previous evening
+	for day := localMidnight(from, loc).AddDate(0, 0, -1); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !days[day.Weekday()] {
+			continue
+		}
+		window := BlackoutWindow{BlackoutID: blackout.ID, Name: blackout.Name, Start: day.Add(start), End: day.Add(end)}
+		if end < start {
+			window.End = window.End.Add(24 * time.Hour)
+		}
+		if window.Start.Before(to) && window.End.After(from) {
+			windows = append(windows, window)
+		}
+	}
+	return windows
+}
+
+// NextBlackoutWindow returns the next occurrence of a blackout that has not ended yet, within the search horizon.
+func NextBlackoutWindow(blackout *models.Blackout, now time.Time) *BlackoutWindow {
+	windows := blackoutOccurrences(blackout, now, now.Add(blackoutHorizon), Settings().Location())
+	if len(windows) == 0 {
+		return nil
+	}
+	return &windows[0]
+}
+
+// BlackoutWindows returns the blackout windows affecting a device that overlap [from, to), in start order.
+func BlackoutWindows(deviceID uint, from, to time.Time) ([]BlackoutWindow, error) {
+	var blackouts []models.Blackout
+	if err := database.GetDB().
+		Where("enabled AND (device_id IS NULL OR device_id = ?)", deviceID).
+		Find(&blackouts).Error; err != nil {
+		return nil, err
+	}
+	loc := Settings().Location()
+	var windows []BlackoutWindow
+	for i := range blackouts {
+		windows = append(windows, blackoutOccurrences(&blackouts[i], from, to, loc)...)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	return windows, nil
+}
+
+// checkBlackout reports whether running the device from start for duration (plus the stop
+// margin) would overlap a blackout, and if so the earliest start that would not.
+func checkBlackout(deviceID uint, start time.Time, duration time.Duration) *BlackoutError {
+	margin := Settings().BlackoutStopMargin
+	windows, err := BlackoutWindows(deviceID, start, start.Add(duration+margin+blackoutHorizon))
+	if err != nil {
+		log.Printf("[Blackout] Failed to load blackouts for device %d: %v", deviceID, err)
+		return nil
+	}
+	return blackoutConflict(deviceID, windows, start, duration, margin)
+}
+
+// blackoutConflict checks a run against blackout windows (in start order), moving the
+// start past each window it would overlap to find the next free slot.
+func blackoutConflict(deviceID uint, windows []BlackoutWindow, start time.Time, duration, margin time.Duration) *BlackoutError {
+	var conflict *BlackoutError
+	slot := start
+	for _, window := range windows {
+		if !window.End.After(slot) {
+			continue
+		}
+		if !window.Start.Before(slot.Add(duration + margin)) {
+			break
+		}
+		// The run would overlap this window: try again after it ends
+		if conflict == nil {
+			conflict = &BlackoutError{DeviceID: deviceID, Window: window}
+		}
+		slot = window.End
+	}
+	if conflict != nil && slot.Before(start.Add(blackoutHorizon)) {
+		conflict.NextSlot = &slot
+	}
+	return conflict
+}
+
+// StartBlackoutWatcher stops running sessions shortly before a blackout begins, until the service shuts down.
+func (ds *DeviceService) StartBlackoutWatcher() {
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ds.base.Done():
+				return
+			case <-ticker.C:
+			}
+			ds.stopSessionsBeforeBlackout(time.Now())
+		}
+	}()
+}
+
+// stopSessionsBeforeBlackout stops every running session whose device has a blackout within the stop margin.
+func (ds *DeviceService) stopSessionsBeforeBlackout(now time.Time) {
+	margin := Settings().BlackoutStopMargin
+
+	ds.activeActivationsMu.Lock()
+	running := make(map[uint]*activeActivation, len(ds.activeActivations))
+	for deviceID, active := range ds.activeActivations {
+		if active.sessionID != 0 && !active.stopping {
+			running[deviceID] = active
+		}
+	}
+	ds.activeActivationsMu.Unlock()
+
+	for deviceID, active := range running {
+		windows, err := BlackoutWindows(deviceID, now, now.Add(margin))
+		if err != nil || len(windows) == 0 {
+			continue
+		}
+		window := windows[0]
+		ds.activeActivationsMu.Lock()
+		active.stopping = true
+		ds.activeActivationsMu.Unlock()
+		detail := fmt.Sprintf("blackout %q starts at %s", window.Name, window.Start.Format("03:04 PM"))
+		log.Printf("[Blackout] Stopping device %d: %s", deviceID, detail)
+		active.cancel(&stopCause{reason: models.ReasonBlackout, detail: detail})
+
+		SendDevicePushNotificationToAll(
+			deviceID,
+			fmt.Sprintf("Device %d was switched off ahead of a scheduled power outage (%s - %s).", deviceID,
+				window.Start.Format("03:04 PM"), window.End.Format("03:04 PM")),
+			map[string]string{
+				"device_id": fmt.Sprintf("%d", deviceID),
+				"action":    "blackout",
+			},
+		)
+	}
+}
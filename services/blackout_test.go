@@ -0,0 +1,147 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+func TestValidateBlackout(t *testing.T) {
+	start := time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+
+	tests := []struct {
+		name     string
+		blackout models.Blackout
+		wantErr  bool
+	}{
+		{"weekly", models.Blackout{DaysOfWeek: "mon,thu", StartTime: "18:00", EndTime: "20:00"}, false},
+		{"weekly across midnight", models.Blackout{DaysOfWeek: "sat", StartTime: "23:00", EndTime: "01:00"}, false},
+		{"weekly bad day", models.Blackout{DaysOfWeek: "mon,funday", StartTime: "18:00", EndTime: "20:00"}, true},
+		{"weekly bad time", models.Blackout{DaysOfWeek: "mon", StartTime: "6pm", EndTime: "20:00"}, true},
+		{"weekly empty slot", models.Blackout{DaysOfWeek: "mon", StartTime: "18:00", EndTime: "18:00"}, true},
+		{"one-off", models.Blackout{StartsAt: &start, EndsAt: &end}, false},
+		{"one-off missing end", models.Blackout{StartsAt: &start}, true},
+		{"one-off reversed", models.Blackout{StartsAt: &end, EndsAt: &start}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateBlackout(&tt.blackout); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateBlackout = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBlackoutOccurrences(t *testing.T) {
+	loc := time.FixedZone("PKT", 5*60*60)
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 10, day, hour, min, 0, 0, loc)
+	}
+	oneOffStart, oneOffEnd := at(17, 9, 0), at(17, 12, 0)
+
+	// 2026-10-16 is a Friday
+	tests := []struct {
+		name     string
+		blackout models.Blackout
+		from, to time.Time
+		want     [][2]time.Time
+	}{
+		{
+			"weekly slots in range", models.Blackout{DaysOfWeek: "fri,sat", StartTime: "18:00", EndTime: "20:00"},
+			at(16, 12, 0), at(18, 0, 0), [][2]time.Time{{at(16, 18, 0), at(16, 20, 0)}, {at(17, 18, 0), at(17, 20, 0)}},
+		},
+		{
+			"slot already under way", models.Blackout{DaysOfWeek: "fri", StartTime: "18:00", EndTime: "20:00"},
+			at(16, 19, 0), at(16, 23, 0), [][2]time.Time{{at(16, 18, 0), at(16, 20, 0)}},
+		},
+		{
+			"slot from the previous evening", models.Blackout{DaysOfWeek: "thu", StartTime: "23:00", EndTime: "02:00"},
+			at(16, 0, 30), at(16, 12, 0), [][2]time.Time{{at(15, 23, 0), at(16, 2, 0)}},
+		},
+		{
+			"slot ended", models.Blackout{DaysOfWeek: "fri", StartTime: "08:00", EndTime: "10:00"},
+			at(16, 10, 0), at(16, 23, 0), nil,
+		},
+		{
+			"one-off overlapping", models.Blackout{StartsAt: &oneOffStart, EndsAt: &oneOffEnd},
+			at(17, 11, 0), at(17, 14, 0), [][2]time.Time{{oneOffStart, oneOffEnd}},
+		},
+		{
+			"one-off outside", models.Blackout{StartsAt: &oneOffStart, EndsAt: &oneOffEnd},
+			at(17, 12, 0), at(17, 14, 0), nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := blackoutOccurrences(&tt.blackout, tt.from, tt.to, loc)
+			if len(got) != len(tt.want) {
+				t.Fatalf("blackoutOccurrences = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Start.Equal(tt.want[i][0]) || !got[i].End.Equal(tt.want[i][1]) {
+					t.Errorf("window %d = %v - %v, want %v - %v", i, got[i].Start, got[i].End, tt.want[i][0], tt.want[i][1])
+				}
+			}
+		})
+	}
+}
+
+func TestBlackoutConflict(t *testing.T) {
+	base := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	at := func(hours, minutes int) time.Time {
+		return base.Add(time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute)
+	}
+	window := func(from, to time.Time) BlackoutWindow {
+		return BlackoutWindow{Name: "load-shedding", Start: from, End: to}
+	}
+	const margin = 2 * time.Minute
+
+	tests := []struct {
+		name         string
+		windows      []BlackoutWindow
+		start        time.Time
+		duration     time.Duration
+		wantConflict bool
+		wantNextSlot time.Time
+	}{
+		{"no windows", nil, at(0, 0), time.Hour, false, time.Time{}},
+		{"ends before the window", []BlackoutWindow{window(at(2, 0), at(3, 0))}, at(0, 0), time.Hour, false, time.Time{}},
+		{"margin reaches the window", []BlackoutWindow{window(at(1, 0), at(2, 0))}, at(0, 0), 59 * time.Minute, true, at(2, 0)},
+		{"starts inside the window", []BlackoutWindow{window(at(-1, 0), at(1, 0))}, at(0, 0), 30 * time.Minute, true, at(1, 0)},
+		{"window already over", []BlackoutWindow{window(at(-2, 0), at(-1, 0))}, at(0, 0), time.Hour, false, time.Time{}},
+		{
+			"gap too short, next slot after the second window",
+			[]BlackoutWindow{window(at(0, 30), at(1, 0)), window(at(1, 30), at(2, 0))},
+			at(0, 0), time.Hour, true, at(2, 0),
+		},
+		{
+			"gap long enough",
+			[]BlackoutWindow{window(at(0, 30), at(1, 0)), window(at(3, 0), at(4, 0))},
+			at(0, 0), time.Hour, true, at(1, 0),
+		},
+		{
+			"no free slot within the horizon",
+			[]BlackoutWindow{window(at(0, 0), base.Add(blackoutHorizon+time.Hour))},
+			at(0, 0), time.Hour, true, time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := blackoutConflict(1, tt.windows, tt.start, tt.duration, margin)
+			if (got != nil) != tt.wantConflict {
+				t.Fatalf("blackoutConflict = %v, want conflict %t", got, tt.wantConflict)
+			}
+			if got == nil {
+				return
+			}
+			switch {
+			case tt.wantNextSlot.IsZero() && got.NextSlot != nil:
+				t.Errorf("NextSlot = %v, want none", *got.NextSlot)
+			case !tt.wantNextSlot.IsZero() && (got.NextSlot == nil || !got.NextSlot.Equal(tt.wantNextSlot)):
+				t.Errorf("NextSlot = %v, want %v", got.NextSlot, tt.wantNextSlot)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+// Cooldown limits reported in CooldownError.Limit
+const (
+	LimitMinOffTime       = "min_off_time"
+	LimitMaxStartsPerHour = "max_starts_per_hour"
+)
+
+// CooldownError reports that a device may not be started again yet.
+type CooldownError struct {
+	DeviceID  uint
+	Limit     string        // LimitMinOffTime or LimitMaxStartsPerHour
+	Remaining time.Duration // Until the device may start
+}
+
+func (e *CooldownError) Error() string {
+	switch e.Limit {
+	case LimitMinOffTime:
+		return fmt.Sprintf("device %d is resting after its last run, try again in %s", e.DeviceID, e.Remaining.Round(time.Second))
+	default:
+		return fmt.Sprintf("device %d has reached its starts per hour, try again in %s", e.DeviceID, e.Remaining.Round(time.Second))
+	}
+}
+
+// deviceCooldown returns the cooldown that keeps the device from starting at now, if any.
+// When both limits apply, the one that lasts longer is reported.
+func deviceCooldown(device *models.Device, now time.Time) *CooldownError {
+	db := database.GetDB()
+
+	var lastEnded *time.Time
+	if device.MinOffTime > 0 {
+		var last models.DeviceSession
+		if err := db.Where("device_id = ? AND ended_at IS NOT NULL", device.ID).Order("ended_at DESC").First(&last).Error; err == nil {
+			lastEnded = last.EndedAt
+		}
+	}
+
+	var starts []time.Time
+	if device.MaxStartsPerHour > 0 {
+		db.Model(&models.DeviceSession{}).
+			Where("device_id = ? AND started_at > ?", device.ID, now.Add(-time.Hour)).
+			Order("started_at").
+			Pluck("started_at", &starts)
+	}
+	return cooldownAt(device, lastEnded, starts, now)
+}
+
+// cooldownAt applies the device's cooldown limits given when its last session ended and
+// its starts in the past hour (oldest first).
+func cooldownAt(device *models.Device, lastEnded *time.Time, starts []time.Time, now time.Time) *CooldownError {
+	var cooldown *CooldownError
+
+	if device.MinOffTime > 0 && lastEnded != nil {
+		if remaining := lastEnded.Add(device.MinOffTime).Sub(now); remaining > 0 {
+			cooldown = &CooldownError{DeviceID: device.ID, Limit: LimitMinOffTime, Remaining: remaining}
+		}
+	}
+
+	if device.MaxStartsPerHour > 0 && len(starts) >= device.MaxStartsPerHour {
+		// The window frees up when enough of the oldest starts have left it
+		oldest := starts[len(starts)-device.MaxStartsPerHour]
+		remaining := oldest.Add(time.Hour).Sub(now)
+		if remaining > 0 && (cooldown == nil || remaining > cooldown.Remaining) {
+			cooldown = &CooldownError{DeviceID: device.ID, Limit: LimitMaxStartsPerHour, Remaining: remaining}
+		}
+	}
+	return cooldown
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+func TestCooldownAt(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+	endedAgo := func(d time.Duration) *time.Time {
+		t := ago(d)
+		return &t
+	}
+
+	tests := []struct {
+		name          string
+		device        models.Device
+		lastEnded     *time.Time
+		starts        []time.Time
+		wantLimit     string // "" for no cooldown
+		wantRemaining time.Duration
+	}{
+		{"no limits", models.Device{}, endedAgo(time.Minute), []time.Time{ago(time.Minute)}, "", 0},
+		{"never ran", models.Device{MinOffTime: 10 * time.Minute}, nil, nil, "", 0},
+		{"resting", models.Device{MinOffTime: 10 * time.Minute}, endedAgo(4 * time.Minute), nil, LimitMinOffTime, 6 * time.Minute},
+		{"rested exactly", models.Device{MinOffTime: 10 * time.Minute}, endedAgo(10 * time.Minute), nil, "", 0},
+		{"under starts per hour", models.Device{MaxStartsPerHour: 3}, nil, []time.Time{ago(50 * time.Minute), ago(20 * time.Minute)}, "", 0},
+		{
+			"starts per hour reached", models.Device{MaxStartsPerHour: 2}, nil,
+			[]time.Time{ago(50 * time.Minute), ago(20 * time.Minute)}, LimitMaxStartsPerHour, 10 * time.Minute,
+		},
+		{
+			"window frees with the oldest start that must leave", models.Device{MaxStartsPerHour: 2}, nil,
+			[]time.Time{ago(55 * time.Minute), ago(40 * time.Minute), ago(5 * time.Minute)}, LimitMaxStartsPerHour, 20 * time.Minute,
+		},
+		{
+			"longer limit wins", models.Device{MinOffTime: 5 * time.Minute, MaxStartsPerHour: 1}, endedAgo(time.Minute),
+			[]time.Time{ago(30 * time.Minute)}, LimitMaxStartsPerHour, 30 * time.Minute,
+		},
+		{
+			"rest outlasts starts window", models.Device{MinOffTime: 30 * time.Minute, MaxStartsPerHour: 1}, endedAgo(time.Minute),
+			[]time.Time{ago(50 * time.Minute)}, LimitMinOffTime, 29 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cooldownAt(&tt.device, tt.lastEnded, tt.starts, now)
+			if tt.wantLimit == "" {
+				if got != nil {
+					t.Fatalf("cooldownAt = %v, want none", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("cooldownAt = nil, want %s for %v", tt.wantLimit, tt.wantRemaining)
+			}
+			if got.Limit != tt.wantLimit || got.Remaining != tt.wantRemaining {
+				t.Errorf("cooldownAt = %s for %v, want %s for %v", got.Limit, got.Remaining, tt.wantLimit, tt.wantRemaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"too few fields", "0 5 * *"},
+		{"too many fields", "0 5 * * * *"},
+		{"minute out of range", "60 5 * * *"},
+		{"hour out of range", "0 24 * * *"},
+		{"day of month zero", "0 5 0 * *"},
+		{"month out of range", "0 5 * 13 *"},
+		{"weekday out of range", "0 5 * * 8"},
+		{"reversed range", "0 10-5 * * *"},
+		{"zero step", "*/0 * * * *"},
+		{"unknown name", "0 5 * foo *"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCron(tt.expr); err == nil {
+				t.Errorf("ParseCron(%q) succeeded, want an error", tt.expr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	loc := time.FixedZone("PKT", 5*60*60)
+	at := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+	// 2026-10-16 is a Friday
+	from := at(2026, time.October, 16, 10, 7)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", from, at(2026, time.October, 16, 10, 8)},
+		{"strictly after", "7 10 * * *", from, at(2026, time.October, 17, 10, 7)},
+		{"later today", "30 17 * * *", from, at(2026, time.October, 16, 17, 30)},
+		{"step", "*/15 * * * *", from, at(2026, time.October, 16, 10, 15)},
+		{"offset step", "5/20 * * * *", from, at(2026, time.October, 16, 10, 25)},
+		{"range and list", "0 8-9,18 * * *", from, at(2026, time.October, 16, 18, 0)},
+		{"weekday name", "30 5 * * mon", from, at(2026, time.October, 19, 5, 30)},
+		{"sunday as 7", "0 6 * * 7", from, at(2026, time.October, 18, 6, 0)},
+		{"month name", "0 0 1 jan *", from, at(2027, time.January, 1, 0, 0)},
+		{"day of month or weekday", "0 12 20 * sat", from, at(2026, time.October, 17, 12, 0)},
+		{"month rollover", "0 0 1 * *", at(2026, time.December, 31, 23, 59), at(2027, time.January, 1, 0, 0)},
+		{"never matches", "0 0 30 2 *", from, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+			if got := cron.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDaysOfWeek(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []time.Weekday
+		wantErr bool
+	}{
+		{list: "", want: nil},
+		{list: "mon,wed,fri", want: []time.Weekday{time.Monday, time.Wednesday, time.Friday}},
+		{list: " SAT , sun ", want: []time.Weekday{time.Saturday, time.Sunday}},
+		{list: "1,7", want: []time.Weekday{time.Monday, time.Sunday}},
+		{list: "mon,xyz", wantErr: true},
+		{list: "8", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			days, err := ParseDaysOfWeek(tt.list)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseDaysOfWeek(%q) succeeded, want an error", tt.list)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDaysOfWeek(%q): %v", tt.list, err)
+			}
+			if len(days) != len(tt.want) {
+				t.Fatalf("ParseDaysOfWeek(%q) = %v, want %v", tt.list, days, tt.want)
+			}
+			for _, day := range tt.want {
+				if !days[day] {
+					t.Errorf("ParseDaysOfWeek(%q) is missing %v", tt.list, day)
+				}
+			}
+		})
+	}
+}
@@ -10,276 +10,860 @@ import (
 
 	"github.com/musabgulfam/pumplink-backend/database"
 	"github.com/musabgulfam/pumplink-backend/models"
+	"gorm.io/gorm"
 )
 
 // DeviceService manages device activations, quota, and MQTT ACKs.
 type DeviceService struct {
-	deviceQueue              chan *DeviceRequest
-	deviceQuotaMutex         sync.Mutex
-	totalUsageTime           time.Duration
-	quotaResetTime           time.Time
-	deviceQuota              time.Duration
-	activeActivations        map[uint]context.CancelFunc
+	wake                     chan struct{}          // Signals the activator that new requests were queued
+	lanes                    map[uint]chan struct{} // Per-device workers, keyed by device ID
+	lanesMu                  sync.Mutex
+	lanesWG                  sync.WaitGroup             // Running lanes, waited for on shutdown
+	base                     context.Context            // Parent of every activation; cancelled on shutdown
+	stopAll                  context.CancelCauseFunc    // Cancels base with the shutdown stop cause
+	shutdownBy               time.Time                  // Deadline of the graceful shutdown; set before base is cancelled
+	resumable                map[uint]*resumableSession // Sessions recovered at startup, waiting for their lane
+	recovering               map[uint]chan struct{}     // Devices still being switched OFF by the reconciler; closed when done
+	quotaMu                  sync.Mutex                 // Serialises quota checks across lanes
+	quotaReservations        map[uint]*DeviceRequest    // Quota held by requests waiting for their ACK, keyed by request ID
+	activeActivations        map[uint]*activeActivation // Activations in progress, keyed by device ID
 	activeActivationsMu      sync.Mutex
+	interlocks               map[string]uint // Interlock group -> device ID currently running in it
+	interlocksMu             sync.Mutex
 	once                     sync.Once
-	acknowledgmentChannels   map[uint]chan struct{}
+	acknowledgmentChannels   map[uint]*pendingAck
 	acknowledgmentChannelsMu sync.Mutex
+	issuedCommands           map[string]*issuedCommand
+	ackCounters              ackCounters
+	relayWatchers            map[uint]chan struct{} // Devices waiting for status telemetry to confirm OFF
+	relayWatchersMu          sync.Mutex
 }
 
 // DeviceRequest represents a request to activate a device.
 type DeviceRequest struct {
-	UserID   uint
-	DeviceID uint
-	Duration time.Duration
+	UserID     uint
+	DeviceID   uint
+	Duration   time.Duration
+	Origin     string // models.OriginManual (default) or models.OriginSchedule
+	ScheduleID *uint  // Set when Origin is models.OriginSchedule
+}
+
+// stopCause explains why an activation was stopped before its deadline.
+type stopCause struct {
+	reason string // Session reason, e.g. models.ReasonForce
+	userID uint   // User who stopped it (0 for system stops)
+	detail string // Extra context for automatic stops
+}
+
+func (c *stopCause) Error() string { return c.reason }
+
+// stopCauseOf returns the stop cause an activation context was cancelled with.
+func stopCauseOf(ctx context.Context) *stopCause {
+	var cause *stopCause
+	if errors.As(context.Cause(ctx), &cause) {
+		return cause
+	}
+	return &stopCause{reason: models.ReasonForce}
+}
+
+// activeActivation is an activation that has been dispatched and not yet turned OFF.
+type activeActivation struct {
+	cancel     context.CancelCauseFunc
+	requestID  uint
+	userID     uint
+	sessionID  uint          // 0 until the device acknowledges the ON command
+	startedAt  time.Time     // When the device was turned ON
+	deadline   time.Time     // When the device is due to turn OFF
+	reschedule chan struct{} // Signals the lane that deadline changed
+	device     models.Device // Device settings at dispatch time (protection thresholds)
+	stopping   bool          // Set once an automatic stop (protection, blackout) has been requested
 }
 
 // NewDeviceService initializes a new DeviceService.
 func NewDeviceService() *DeviceService {
+	base, stopAll := context.WithCancelCause(context.Background())
 	return &DeviceService{
-		deviceQueue:            make(chan *DeviceRequest),
-		deviceQuota:            1 * time.Hour,
-		quotaResetTime:         time.Now().Add(24 * time.Hour),
-		activeActivations:      make(map[uint]context.CancelFunc),
-		acknowledgmentChannels: make(map[uint]chan struct{}),
+		base:                   base,
+		stopAll:                stopAll,
+		wake:                   make(chan struct{}, 1),
+		lanes:                  make(map[uint]chan struct{}),
+		resumable:              make(map[uint]*resumableSession),
+		recovering:             make(map[uint]chan struct{}),
+		quotaReservations:      make(map[uint]*DeviceRequest),
+		activeActivations:      make(map[uint]*activeActivation),
+		interlocks:             make(map[string]uint),
+		acknowledgmentChannels: make(map[uint]*pendingAck),
+		issuedCommands:         make(map[string]*issuedCommand),
+		relayWatchers:          make(map[uint]chan struct{}),
 	}
 }
 
-// StartActivator launches the device activation loop (only once).
+// StartActivator reconciles device state, resumes pending requests and launches the device activation loop (only once).
 func (ds *DeviceService) StartActivator() {
 	ds.once.Do(func() {
+		ds.Reconcile()
+		ds.resumePending()
+		ds.lanesMu.Lock()
+		resumable := make([]uint, 0, len(ds.resumable))
+		for deviceID := range ds.resumable {
+			resumable = append(resumable, deviceID)
+		}
+		ds.lanesMu.Unlock()
+		for _, deviceID := range resumable {
+			ds.pokeLane(deviceID)
+		}
 		go ds.activatorLoop()
+		go ds.controlLoop()
+		ds.StartBlackoutWatcher()
 	})
 }
 
-type QueueFullError struct{}
+var ErrDeviceNotFound = errors.New("device not found")
+var ErrDeviceOffline = errors.New("device is offline")
+var ErrDeviceBusy = errors.New("device is running")
+var ErrDeviceFault = errors.New("device is in FAULT: it did not confirm its last OFF command")
+
+// EnqueueActivation stores a device activation request in the queue and wakes the activator.
+func (ds *DeviceService) EnqueueActivation(req *DeviceRequest) (*models.ActivationRequest, error) {
+	db := database.GetDB()
+	var device models.Device
+	if err := db.First(&device, req.DeviceID).Error; err != nil {
+		log.Printf("[Queue] Device %d not found. Rejecting request.", req.DeviceID)
+		return nil, ErrDeviceNotFound
+	}
+	if device.State == models.StateFault {
+		log.Printf("[Queue] Device %d is in FAULT. Rejecting request.", req.DeviceID)
+		return nil, ErrDeviceFault
+	}
+	if UnderMaintenance(device.ID) {
+		log.Printf("[Queue] Device %d is under maintenance. Rejecting request.", req.DeviceID)
+		return nil, ErrDeviceInMaintenance
+	}
+	if !DeviceIsOnline(&device) {
+		log.Printf("[Queue] Device %d is offline. Rejecting request.", req.DeviceID)
+		return nil, ErrDeviceOffline
+	}
+	if limit := checkRunTime(&device, req.Duration); limit != nil {
+		log.Printf("[Queue] Rejecting request for User %d | Device %d: %v", req.UserID, req.DeviceID, limit)
+		return nil, limit
+	}
+	if limit := checkContinuousRuntime(&device, time.Now(), req.Duration, 0); limit != nil {
+		log.Printf("[Queue] Rejecting request for User %d | Device %d: %v", req.UserID, req.DeviceID, limit)
+		return nil, limit
+	}
 
-var ErrQueueFull = &QueueFullError{}
-var ErrDeviceAlreadyActive = errors.New("device is already active")
+	// Reject requests that cannot fit in today's quota up front
+	if err := ds.checkQuota(req.UserID, req.DeviceID, req.Duration); err != nil {
+		log.Printf("[Quota] Rejecting request for User %d | Device %d: %v", req.UserID, req.DeviceID, err)
+		return nil, err
+	}
 
-func (e *QueueFullError) Error() string { return "queue is full" }
+	// The motor is still resting or has started too often: reject or hold the request
+	statusReason := ""
+	if cooldown := deviceCooldown(&device, time.Now()); cooldown != nil {
+		if device.CooldownPolicy == models.PolicyReject {
+			log.Printf("[Cooldown] Rejecting request for User %d | Device %d: %v", req.UserID, req.DeviceID, cooldown)
+			return nil, cooldown
+		}
+		statusReason = "waiting for cooldown: " + cooldown.Error()
+	}
 
-// EnqueueActivation adds a device activation request to the queue.
-func (ds *DeviceService) EnqueueActivation(req *DeviceRequest) error {
-	// Check if device is already being activated
-	ds.activeActivationsMu.Lock()
-	_, alreadyActive := ds.activeActivations[req.DeviceID]
-	ds.activeActivationsMu.Unlock()
-	if alreadyActive {
-		log.Printf("[Queue] Device %d is already active. Rejecting request.", req.DeviceID)
-		return ErrDeviceAlreadyActive
+	// The run would overlap a scheduled power outage: reject or hold it for the next free slot
+	if blackout := checkBlackout(req.DeviceID, time.Now(), req.Duration); blackout != nil {
+		if Settings().BlackoutPolicy == models.PolicyReject {
+			log.Printf("[Blackout] Rejecting request for User %d | Device %d: %v", req.UserID, req.DeviceID, blackout)
+			return nil, blackout
+		}
+		statusReason = "waiting for blackout: " + blackout.Error()
+	}
+
+	// Another member of the device's interlock group is running: reject or hold the request
+	if conflict := ds.InterlockConflict(&device); conflict != nil {
+		if device.InterlockPolicy == models.PolicyReject {
+			log.Printf("[Interlock] Rejecting request for User %d | Device %d: %v", req.UserID, req.DeviceID, conflict)
+			return nil, conflict
+		}
+		statusReason = "waiting for interlock: " + conflict.Error()
+	}
+
+	origin := req.Origin
+	if origin == "" {
+		origin = models.OriginManual
+	}
+	activation := &models.ActivationRequest{
+		UserID:       req.UserID,
+		DeviceID:     req.DeviceID,
+		Duration:     req.Duration,
+		Origin:       origin,
+		ScheduleID:   req.ScheduleID,
+		Status:       models.ActivationQueued,
+		StatusReason: statusReason,
+	}
+	if err := db.Create(activation).Error; err != nil {
+		log.Printf("[Queue] Failed to store request for User %d | Device %d: %v", req.UserID, req.DeviceID, err)
+		return nil, err
 	}
+	log.Printf("[Queue] Request %d enqueued for User %d | Device %d\n", activation.ID, req.UserID, req.DeviceID)
+	ds.notify()
+	return activation, nil
+}
 
+// notify wakes the activator without blocking.
+func (ds *DeviceService) notify() {
 	select {
-	case ds.deviceQueue <- req:
-		log.Printf("[Queue] Request enqueued for User %d | Device %d\n", req.UserID, req.DeviceID)
-		return nil
+	case ds.wake <- struct{}{}:
 	default:
-		log.Println("[Queue] Queue is full. Cannot accept more requests.")
-		return ErrQueueFull
 	}
 }
 
-// Helper for waiting for device ACK, timeout, or force shutdown.
-// Returns true if ACK received, false otherwise.
-func (ds *DeviceService) waitForAck(ctx context.Context, deviceID uint, ackTimeout time.Duration) bool {
-	acknowledgmentChannel := make(chan struct{})
-	ds.acknowledgmentChannelsMu.Lock()
-	ds.acknowledgmentChannels[deviceID] = acknowledgmentChannel
-	ds.acknowledgmentChannelsMu.Unlock()
-	defer func() {
-		ds.acknowledgmentChannelsMu.Lock()
-		delete(ds.acknowledgmentChannels, deviceID)
-		ds.acknowledgmentChannelsMu.Unlock()
-	}()
+// resumePending prepares the queue left behind by a previous process.
+// Requests that were waiting for an ACK are queued again; running requests
+// have already been handled by Reconcile.
+func (ds *DeviceService) resumePending() {
+	db := database.GetDB()
+	if err := db.Model(&models.ActivationRequest{}).
+		Where("status = ?", models.ActivationDispatching).
+		Updates(map[string]interface{}{
+			"status":        models.ActivationQueued,
+			"status_reason": "requeued after restart",
+		}).Error; err != nil {
+		log.Printf("[Queue] Failed to requeue dispatching requests: %v", err)
+	}
 
-	select {
-	case <-acknowledgmentChannel:
-		log.Printf("[ACK] Received ACK for device %d", deviceID)
-		return true
-	case <-time.After(ackTimeout):
-		log.Printf("[ACK] Timeout waiting for ACK from device %d", deviceID)
-		Publish(MQTTTopicDeviceControl, "off", 2, true)
-		SendDevicePushNotificationToAdmin(
-			deviceID,
-			fmt.Sprintf("Device %d failed to acknowledge. Activation aborted!", deviceID),
-			map[string]string{"device_id": fmt.Sprintf("%d", deviceID)},
-		)
-		return false
-	case <-ctx.Done():
-		log.Printf("[Force] Activation for device %d cancelled by admin during ACK wait", deviceID)
-		Publish(MQTTTopicDeviceControl, "off", 2, true)
-		SendDevicePushNotificationToAdmin(
-			deviceID,
-			fmt.Sprintf("Activation for device %d cancelled by admin during ACK wait", deviceID),
-			map[string]string{"device_id": fmt.Sprintf("%d", deviceID)},
-		)
-		return false
+	var pending int64
+	db.Model(&models.ActivationRequest{}).Where("status = ?", models.ActivationQueued).Count(&pending)
+	if pending > 0 {
+		log.Printf("[Queue] Resuming %d pending requests", pending)
 	}
 }
 
-// activatorLoop processes device activation requests from the queue.
-func (ds *DeviceService) activatorLoop() {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("[Panic] Device activator recovered: %v", r)
-		}
-	}()
+// holdRequest puts a request that cannot start yet back in the queue until notBefore.
+func holdRequest(req *models.ActivationRequest, reason string, notBefore time.Time) {
+	req.Status = models.ActivationQueued
+	req.StatusReason = reason
+	req.NotBefore = &notBefore
+	if err := database.GetDB().Model(req).Updates(map[string]interface{}{
+		"status":        models.ActivationQueued,
+		"status_reason": reason,
+		"not_before":    notBefore,
+	}).Error; err != nil {
+		log.Printf("[Queue] Failed to hold request %d: %v", req.ID, err)
+	}
+}
 
-	for req := range ds.deviceQueue {
-		log.Printf("[Queue] Processing request for User %d | Device %d | Duration %v\n", req.UserID, req.DeviceID, req.Duration)
+// setStatus moves a request to a new status and records why.
+func setStatus(req *models.ActivationRequest, status, reason string) {
+	updates := map[string]interface{}{
+		"status":        status,
+		"status_reason": reason,
+	}
+	req.Status = status
+	req.StatusReason = reason
+	if req.IsFinal() {
+		now := time.Now()
+		req.FinishedAt = &now
+		updates["finished_at"] = now
+	}
+	if err := database.GetDB().Model(req).Updates(updates).Error; err != nil {
+		log.Printf("[Queue] Failed to set request %d to %s: %v", req.ID, status, err)
+	}
+}
 
-		// Reset quota every 24 hours
-		if time.Now().After(ds.quotaResetTime) {
-			ds.deviceQuotaMutex.Lock()
-			ds.totalUsageTime = 0
-			ds.quotaResetTime = time.Now().Add(24 * time.Hour)
-			ds.deviceQuotaMutex.Unlock()
-			log.Println("[Quota] Daily quota has been reset")
-		}
+// defaultAckTimeout is the first ACK wait for devices without their own AckTimeout.
+const defaultAckTimeout = 10 * time.Second
 
-		// Check if this request exceeds daily quota
-		ds.deviceQuotaMutex.Lock()
-		if req.Duration+ds.totalUsageTime > ds.deviceQuota {
-			ds.deviceQuotaMutex.Unlock()
-			log.Printf("[Quota] Quota exceeded for User %d. Skipping request.\n", req.UserID)
-			continue
+// maxAckTimeout caps the ACK wait as it doubles between attempts. A longer AckTimeout is kept as is.
+const maxAckTimeout = 2 * time.Minute
+
+// firstAckTimeout is how long the first command to a device waits for its ACK.
+func firstAckTimeout(device *models.Device) time.Duration {
+	if device.AckTimeout > 0 {
+		return device.AckTimeout
+	}
+	return defaultAckTimeout
+}
+
+// nextAckTimeout doubles an ACK wait for the next attempt, up to maxAckTimeout. The wait never shrinks.
+func nextAckTimeout(timeout time.Duration) time.Duration {
+	return max(timeout, min(2*timeout, maxAckTimeout))
+}
+
+// sendOnWithRetry publishes the ON command and waits for the device ACK, republishing up to
+// config.MaxRetries times with the wait doubling after each attempt. Every attempt is recorded
+// on the request. Returns true once any attempt is acknowledged; false on give-up or cancellation,
+// in which case the device is told to turn OFF.
+func (ds *DeviceService) sendOnWithRetry(ctx context.Context, req *models.ActivationRequest, device *models.Device) bool {
+	deviceID := device.ID
+	db := database.GetDB()
+	pending := ds.expectAck(device)
+	defer ds.clearAck(deviceID, pending)
+
+	timeout := firstAckTimeout(device)
+	maxAttempts := Settings().MaxRetries + 1
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var attempts []*models.ActivationAttempt
+	finish := func(attempt *models.ActivationAttempt, outcome string) {
+		attempt.Outcome = outcome
+		if err := db.Model(attempt).Updates(map[string]interface{}{
+			"Outcome": outcome,
+			"AckedAt": attempt.AckedAt,
+		}).Error; err != nil {
+			log.Printf("[ACK] Failed to record attempt %d of request %d: %v", attempt.Attempt, req.ID, err)
 		}
-		ds.deviceQuotaMutex.Unlock()
+	}
 
-		db := database.GetDB()
-		var device models.Device
-		if err := db.Where("id = ?", req.DeviceID).First(&device).Error; err != nil {
-			log.Printf("[DB] Device not found: %d\n", req.DeviceID)
-			continue
+	for n := 1; n <= maxAttempts; n++ {
+		// Publish ON command to the device's control topic (QoS 2, retained)
+		commandID, err := ds.publishCommand(device, "on")
+		attempt := &models.ActivationAttempt{
+			ActivationRequestID: req.ID,
+			Attempt:             n,
+			CommandID:           commandID,
+			SentAt:              time.Now(),
+			Timeout:             timeout,
+			Outcome:             models.AttemptPending,
+		}
+		if err != nil {
+			log.Printf("[MQTT] Failed to publish ON command to device %d (attempt %d): %v", deviceID, n, err)
+			attempt.Error = err.Error()
+		} else {
+			ds.awaitCommand(pending, commandID)
 		}
+		if err := db.Create(attempt).Error; err != nil {
+			log.Printf("[ACK] Failed to record attempt %d of request %d: %v", n, req.ID, err)
+		}
+		attempts = append(attempts, attempt)
 
-		if device.State == "ON" {
-			log.Printf("[State] Device %d already ON. Skipping.\n", req.DeviceID)
-			continue
+		select {
+		case ackedID := <-pending.ch:
+			now := time.Now()
+			// A late ACK for an earlier publish also means the device is ON
+			acked := attempt
+			for _, earlier := range attempts {
+				if ackedID != "" && earlier.CommandID == ackedID {
+					acked = earlier
+				}
+			}
+			acked.AckedAt = &now
+			finish(acked, models.AttemptAcked)
+			if acked != attempt {
+				finish(attempt, models.AttemptTimedOut)
+			}
+			log.Printf("[ACK] Received ACK for command %s from device %d (attempt %d of %d)", acked.CommandID, deviceID, acked.Attempt, maxAttempts)
+			return true
+		case <-time.After(timeout):
+			outcome := models.AttemptTimedOut
+			if attempt.Error != "" {
+				outcome = models.AttemptPublishFailed
+			}
+			finish(attempt, outcome)
+			log.Printf("[ACK] No ACK for command %s from device %d after %v (attempt %d of %d)", commandID, deviceID, timeout, n, maxAttempts)
+		case <-ctx.Done():
+			finish(attempt, models.AttemptCancelled)
+			cause := stopCauseOf(ctx)
+			log.Printf("[Force] Activation for device %d cancelled during ACK wait (%s)", deviceID, cause.reason)
+			ds.publishCommand(device, "off")
+			SendDevicePushNotificationToAdmin(
+				deviceID,
+				fmt.Sprintf("Activation for device %d cancelled during ACK wait (%s)", deviceID, cause.reason),
+				map[string]string{"device_id": fmt.Sprintf("%d", deviceID)},
+			)
+			return false
 		}
 
-		ctx, cancel := context.WithCancel(context.Background())
+		timeout = nextAckTimeout(timeout)
+	}
 
-		// Publish ON command to device MQTT broker (QoS 2, retained)
-		Publish(MQTTTopicDeviceControl, "on", 2, true)
+	log.Printf("[ACK] Device %d did not acknowledge after %d attempts", deviceID, maxAttempts)
+	ds.publishCommand(device, "off")
+	SendDevicePushNotificationToAdmin(
+		deviceID,
+		fmt.Sprintf("Device %d failed to acknowledge after %d attempts. Activation aborted!", deviceID, maxAttempts),
+		map[string]string{"device_id": fmt.Sprintf("%d", deviceID)},
+	)
+	return false
+}
 
-		// Wait for ACK, timeout, or force shutdown
-		ackTimeout := 10 * time.Second
-		ackReceived := ds.waitForAck(ctx, req.DeviceID, ackTimeout)
-		if !ackReceived {
-			cancel()
+// queuePollInterval is how often the activator looks for requests queued by other replicas.
+const queuePollInterval = 2 * time.Second
+
+// activatorLoop hands queued requests to one lane per device, so different
+// devices run at the same time while each device keeps its requests in order.
+func (ds *DeviceService) activatorLoop() {
+	db := database.GetDB()
+	for {
+		var deviceIDs []uint
+		if err := db.Model(&models.ActivationRequest{}).
+			Where("status = ? AND (not_before IS NULL OR not_before <= ?)", models.ActivationQueued, time.Now()).
+			Distinct().Pluck("device_id", &deviceIDs).Error; err != nil {
+			log.Printf("[Queue] Failed to fetch queued devices: %v", err)
+			select {
+			case <-ds.wake:
+			case <-time.After(5 * time.Second):
+			}
 			continue
 		}
+		for _, deviceID := range deviceIDs {
+			ds.pokeLane(deviceID)
+		}
+		// Requests queued by other replicas only show up on the next poll
+		select {
+		case <-ds.wake:
+		case <-time.After(queuePollInterval):
+		case <-ds.base.Done():
+			return
+		}
+	}
+}
 
-		// Create a new device session with intended duration and active until
-		startTime := time.Now()
-		intendedDuration := req.Duration.String()
-		activeUntil := startTime.Add(req.Duration)
-		session := models.DeviceSession{
-			UserID:           req.UserID,
-			DeviceID:         req.DeviceID,
-			IntendedDuration: intendedDuration,
-			ActiveUntil:      activeUntil,
-			Reason:           "",
+// pokeLane wakes the lane for a device, starting it on first use (but not once shutting down).
+func (ds *DeviceService) pokeLane(deviceID uint) {
+	ds.lanesMu.Lock()
+	lane, exists := ds.lanes[deviceID]
+	if !exists {
+		if ds.base.Err() != nil {
+			ds.lanesMu.Unlock()
+			return
 		}
-		if err := db.Create(&session).Error; err != nil {
-			log.Printf("[DB] Failed to create device session for User %d | Device %d: %v\n", req.UserID, req.DeviceID, err)
-			cancel()
+		lane = make(chan struct{}, 1)
+		ds.lanes[deviceID] = lane
+		ds.lanesWG.Add(1)
+		go ds.laneLoop(deviceID, lane)
+	}
+	ds.lanesMu.Unlock()
+
+	select {
+	case lane <- struct{}{}:
+	default:
+	}
+}
+
+// laneLoop processes the queued requests of a single device in order.
+// It returns once the service shuts down, leaving the rest of the queue for the next instance.
+func (ds *DeviceService) laneLoop(deviceID uint, lane chan struct{}) {
+	defer ds.lanesWG.Done()
+
+	// The reconciler may still be switching the device OFF; its queue waits until it is done
+	if !ds.waitRecovery(deviceID) {
+		return
+	}
+
+	// A session recovered at startup runs before anything queued behind it
+	if resumable := ds.takeResumable(deviceID); resumable != nil {
+		ds.resumeSession(resumable)
+	}
+
+	db := database.GetDB()
+	for ds.base.Err() == nil {
+		var req models.ActivationRequest
+		err := db.Where("status = ? AND device_id = ?", models.ActivationQueued, deviceID).Order("id").First(&req).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ds.waitLane(lane)
 			continue
 		}
-
-		if err := db.Model(&device).Update("state", "ON").Error; err != nil {
-			log.Printf("[DB] Failed to update device state to ON.%d\n", req.DeviceID)
-			cancel()
+		if err != nil {
+			log.Printf("[Queue] Failed to fetch next request for device %d: %v", deviceID, err)
+			select {
+			case <-lane:
+			case <-ds.base.Done():
+			case <-time.After(5 * time.Second):
+			}
 			continue
 		}
-
-		if err := db.Create(&models.DeviceLog{
-			State:     "ON",
-			SessionID: session.ID,
-		}).Error; err != nil {
-			log.Printf("[Log] Failed to create ON log for device %d\n", req.DeviceID)
-		} else {
-			log.Printf("[Log] ON state logged for device %d\n", req.DeviceID)
+		if req.NotBefore != nil && req.NotBefore.After(time.Now()) {
+			// Held for cooldown, blackout or interlock; leave it alone until then
+			ds.waitLaneUntil(lane, *req.NotBefore)
+			continue
 		}
+		ds.processActivation(&req)
+	}
+}
 
-		log.Printf("[State] Device %d will remain ON for %v\n", req.DeviceID, req.Duration)
-
-		SendDevicePushNotificationToAll(
-			req.DeviceID,
-			fmt.Sprintf("Device %d is now ON for %v minutes.", req.DeviceID, req.Duration.Minutes()),
-			map[string]string{
-				"device_id": fmt.Sprintf("%d", req.DeviceID),
-				"action":    "on",
-				"duration":  fmt.Sprintf("%f", req.Duration.Minutes()),
-			},
-		)
+// waitLane blocks until the lane is poked or the service shuts down.
+func (ds *DeviceService) waitLane(lane chan struct{}) {
+	select {
+	case <-lane:
+	case <-ds.base.Done():
+	}
+}
 
-		// Register this activation for force shutdown
-		ds.activeActivationsMu.Lock()
-		ds.activeActivations[req.DeviceID] = cancel
-		ds.activeActivationsMu.Unlock()
+// waitLaneUntil is waitLane that also returns at the given time.
+func (ds *DeviceService) waitLaneUntil(lane chan struct{}, until time.Time) {
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	select {
+	case <-lane:
+	case <-timer.C:
+	case <-ds.base.Done():
+	}
+}
 
-		// Wait for duration or force shutdown
-		startTime = time.Now()
-		var shutdownReason string
-		select {
-		case <-time.After(req.Duration):
-			shutdownReason = "completed"
-		case <-ctx.Done():
-			shutdownReason = "force"
-			log.Printf("[Force] Activation for device %d cancelled by admin", req.DeviceID)
+// processActivation runs a single activation request from ON command to OFF.
+// A request that cannot start yet is put back in the queue with the time to try again.
+func (ds *DeviceService) processActivation(req *models.ActivationRequest) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[Panic] Device activator recovered: %v", r)
+			setStatus(req, models.ActivationRejected, "internal error")
 		}
-		shutdownTime := time.Now()
-		actualDuration := shutdownTime.Sub(startTime)
+	}()
 
-		// Deduct only the actual ON duration from quota
-		ds.deviceQuotaMutex.Lock()
-		ds.totalUsageTime += actualDuration
-		ds.deviceQuotaMutex.Unlock()
+	log.Printf("[Queue] Processing request %d for User %d | Device %d | Duration %v\n", req.ID, req.UserID, req.DeviceID, req.Duration)
 
-		// Clean up after activation
+	ctx, cancel := context.WithCancelCause(ds.base)
+	defer cancel(nil)
+
+	// A request interrupted by a shutdown before its session started is left for the next instance
+	sessionStarted := false
+	defer func() {
+		if !sessionStarted && req.Status == models.ActivationCancelled && ds.base.Err() != nil && context.Cause(ctx) == context.Cause(ds.base) {
+			requeue(req, "requeued at shutdown")
+		}
+	}()
+
+	// Register this activation for force shutdown and user cancellation
+	active := &activeActivation{
+		cancel:     cancel,
+		requestID:  req.ID,
+		userID:     req.UserID,
+		reschedule: make(chan struct{}, 1),
+	}
+	ds.activeActivationsMu.Lock()
+	ds.activeActivations[req.DeviceID] = active
+	ds.activeActivationsMu.Unlock()
+	defer func() {
 		ds.activeActivationsMu.Lock()
 		delete(ds.activeActivations, req.DeviceID)
 		ds.activeActivationsMu.Unlock()
+	}()
 
-		// Publish OFF command to device MQTT broker
-		Publish(MQTTTopicDeviceControl, "off", 2, true)
+	// Claim the request; it may have been withdrawn since the lane fetched it
+	db := database.GetDB()
+	claim := db.Model(req).Where("status = ?", models.ActivationQueued).Update("status", models.ActivationDispatching)
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		log.Printf("[Queue] Request %d is no longer queued. Skipping.", req.ID)
+		return
+	}
+	req.Status = models.ActivationDispatching
 
-		if err := db.Model(&device).Update("state", "OFF").Error; err != nil {
-			log.Printf("[DB] Failed to turn OFF device %d\n", req.DeviceID)
-			cancel()
-			continue
+	var device models.Device
+	if err := db.Where("id = ?", req.DeviceID).First(&device).Error; err != nil {
+		log.Printf("[DB] Device not found: %d\n", req.DeviceID)
+		setStatus(req, models.ActivationRejected, "device not found")
+		return
+	}
+	ds.activeActivationsMu.Lock()
+	active.device = device
+	ds.activeActivationsMu.Unlock()
+
+	if DeviceBusy(&device) {
+		log.Printf("[State] Device %d already %s. Skipping.\n", req.DeviceID, device.State)
+		setStatus(req, models.ActivationRejected, "device already "+device.State)
+		return
+	}
+
+	if device.State == models.StateFault {
+		log.Printf("[State] Device %d is in FAULT. Skipping.\n", req.DeviceID)
+		setStatus(req, models.ActivationRejected, ErrDeviceFault.Error())
+		return
+	}
+
+	if device.State == models.StateMaintenance || UnderMaintenance(device.ID) {
+		log.Printf("[State] Device %d is under maintenance. Skipping.\n", req.DeviceID)
+		setStatus(req, models.ActivationRejected, ErrDeviceInMaintenance.Error())
+		return
+	}
+
+	if !DeviceIsOnline(&device) {
+		log.Printf("[State] Device %d went offline while the request was queued. Skipping.\n", req.DeviceID)
+		setStatus(req, models.ActivationRejected, ErrDeviceOffline.Error())
+		return
+	}
+
+	// Device limits may have changed, and earlier requests may have run, since this one was queued
+	limit := checkRunTime(&device, req.Duration)
+	if limit == nil {
+		limit = checkContinuousRuntime(&device, time.Now(), req.Duration, 0)
+	}
+	if limit != nil {
+		log.Printf("[Queue] Rejecting request %d: %v", req.ID, limit)
+		setStatus(req, models.ActivationRejected, limit.Error())
+		return
+	}
+
+	// Let the motor rest between runs; a held request is retried when the cooldown ends
+	if cooldown := deviceCooldown(&device, time.Now()); cooldown != nil {
+		switch {
+		case ctx.Err() != nil:
+			setStatus(req, models.ActivationCancelled, stopCauseOf(ctx).reason)
+		case device.CooldownPolicy == models.PolicyReject:
+			log.Printf("[Cooldown] Rejecting request %d: %v", req.ID, cooldown)
+			setStatus(req, models.ActivationRejected, cooldown.Error())
+		default:
+			log.Printf("[Cooldown] Holding request %d: %v", req.ID, cooldown)
+			holdRequest(req, "waiting for cooldown: "+cooldown.Error(), time.Now().Add(cooldown.Remaining))
 		}
-		log.Printf("[State] Device %d turned OFF at %s after %v\n", req.DeviceID, shutdownTime.Format("03:04 PM"), req.Duration)
+		return
+	}
 
-		if err := db.Create(&models.DeviceLog{
-			State:     "OFF",
-			SessionID: session.ID,
-		}).Error; err != nil {
-			log.Printf("[Log] Failed to create OFF log for device %d\n", req.DeviceID)
-		} else {
-			log.Printf("[Log] OFF state logged for device %d (was ON for %v, reason: %s)\n", req.DeviceID, actualDuration, shutdownReason)
+	// Keep clear of scheduled power outages; a held request is retried at the next free slot
+	if blackout := checkBlackout(req.DeviceID, time.Now(), req.Duration); blackout != nil {
+		switch {
+		case ctx.Err() != nil:
+			setStatus(req, models.ActivationCancelled, stopCauseOf(ctx).reason)
+		case Settings().BlackoutPolicy == models.PolicyReject || blackout.NextSlot == nil:
+			log.Printf("[Blackout] Rejecting request %d: %v", req.ID, blackout)
+			setStatus(req, models.ActivationRejected, blackout.Error())
+		default:
+			log.Printf("[Blackout] Holding request %d: %v", req.ID, blackout)
+			holdRequest(req, "waiting for blackout: "+blackout.Error(), *blackout.NextSlot)
 		}
+		return
+	}
 
-		if err := db.Model(&session).Updates(map[string]interface{}{
-			"ActiveUntil": shutdownTime.Format(time.RFC3339),
-			"Reason":      shutdownReason,
-		}).Error; err != nil {
-			log.Printf("[DB] Failed to update device session for device %d: %v\n", req.DeviceID, err)
+	// Only one member of an interlock group may run at a time
+	if conflict := ds.acquireInterlock(&device); conflict != nil {
+		switch {
+		case ctx.Err() != nil:
+			setStatus(req, models.ActivationCancelled, stopCauseOf(ctx).reason)
+		case device.InterlockPolicy == models.PolicyReject:
+			log.Printf("[Interlock] Rejecting request %d: %v", req.ID, conflict)
+			setStatus(req, models.ActivationRejected, conflict.Error())
+		default:
+			log.Printf("[Interlock] Holding request %d: %v", req.ID, conflict)
+			// Released groups wake their held requests early; the recheck catches members switched on elsewhere
+			holdRequest(req, interlockHoldReason+conflict.Error(), time.Now().Add(interlockRecheckInterval))
+		}
+		return
+	}
+	// A device left in FAULT may still be running, so it keeps the group until the fault is resolved
+	defer func() {
+		if device.State != models.StateFault {
+			ds.releaseInterlock(&device)
+		}
+	}()
+
+	// Check if this request exceeds today's user or device quota (resets at local midnight)
+	// and hold its share until the session exists, so other lanes cannot spend it meanwhile
+	if err := ds.reserveQuota(req); err != nil {
+		log.Printf("[Quota] %v (User %d | Device %d). Skipping request.\n", err, req.UserID, req.DeviceID)
+		setStatus(req, models.ActivationRejected, err.Error())
+		return
+	}
+	defer ds.releaseQuota(req.ID)
+
+	if ctx.Err() != nil {
+		setStatus(req, models.ActivationCancelled, stopCauseOf(ctx).reason)
+		return
+	}
+
+	if err := setDeviceState(&device, models.StateStarting, stateChange{cause: models.CauseActivation, actorID: req.UserID},
+		models.StateIdle, models.StateOffline); err != nil {
+		log.Printf("[State] Device %d cannot start: %v", req.DeviceID, err)
+		setStatus(req, models.ActivationRejected, err.Error())
+		return
+	}
+
+	// Publish ON and wait for the ACK, retrying with backoff, unless the activation is stopped
+	ackReceived := ds.sendOnWithRetry(ctx, req, &device)
+	if !ackReceived {
+		if ctx.Err() != nil {
+			setStatus(req, models.ActivationCancelled, stopCauseOf(ctx).reason)
+		} else {
+			setStatus(req, models.ActivationRejected, "device did not acknowledge")
 		}
+		logTransitionError(req.DeviceID, setDeviceState(&device, restingState(&device),
+			stateChange{cause: models.CauseStartAborted, detail: req.StatusReason}))
+		return
+	}
+
+	// Create a new device session with intended duration and active until
+	startTime := time.Now()
+	intendedDuration := req.Duration.String()
+	activeUntil := startTime.Add(req.Duration)
+	session := models.DeviceSession{
+		UserID:           req.UserID,
+		DeviceID:         req.DeviceID,
+		IntendedDuration: intendedDuration,
+		ActiveUntil:      activeUntil,
+		StartedAt:        startTime,
+		Reason:           "",
+		Origin:           req.Origin,
+		ScheduleID:       req.ScheduleID,
+	}
+	if err := db.Create(&session).Error; err != nil {
+		log.Printf("[DB] Failed to create device session for User %d | Device %d: %v\n", req.UserID, req.DeviceID, err)
+		ds.publishCommand(&device, "off")
+		setStatus(req, models.ActivationRejected, "failed to create session")
+		logTransitionError(req.DeviceID, setDeviceState(&device, restingState(&device),
+			stateChange{cause: models.CauseStartAborted, detail: req.StatusReason}))
+		return
+	}
+
+	sessionStarted = true
 
-		cancel()
+	// The open session now accounts for this run time in the quota
+	ds.releaseQuota(req.ID)
+
+	if err := setDeviceState(&device, models.StateRunning, stateChange{cause: models.CauseAcknowledged, sessionID: session.ID}); err != nil {
+		log.Printf("[DB] Failed to update device state to RUNNING.%d: %v\n", req.DeviceID, err)
+		ds.publishCommand(&device, "off")
+		setStatus(req, models.ActivationRejected, "failed to update device state")
+		return
+	}
+
+	if err := db.Model(req).Updates(map[string]interface{}{
+		"status":     models.ActivationRunning,
+		"session_id": session.ID,
+		"started_at": startTime,
+	}).Error; err != nil {
+		log.Printf("[Queue] Failed to mark request %d running: %v", req.ID, err)
+	}
+
+	if err := db.Create(&models.DeviceLog{
+		State:     "ON",
+		SessionID: session.ID,
+	}).Error; err != nil {
+		log.Printf("[Log] Failed to create ON log for device %d\n", req.DeviceID)
+	} else {
+		log.Printf("[Log] ON state logged for device %d\n", req.DeviceID)
 	}
+
+	log.Printf("[State] Device %d will remain ON for %v\n", req.DeviceID, req.Duration)
+
+	SendDevicePushNotificationToAll(
+		req.DeviceID,
+		fmt.Sprintf("Device %d is now ON for %v minutes.", req.DeviceID, req.Duration.Minutes()),
+		map[string]string{
+			"device_id": fmt.Sprintf("%d", req.DeviceID),
+			"action":    "on",
+			"duration":  fmt.Sprintf("%f", req.Duration.Minutes()),
+		},
+	)
+
+	// Wait for duration (which the owner may extend or shorten) or force shutdown
+	startTime = time.Now()
+	ds.runSession(ctx, active, req, &device, &session, startTime, startTime.Add(req.Duration), models.ReasonCompleted)
 }
 
-// ForceShutdown cancels an active device activation (admin action).
-func (ds *DeviceService) ForceShutdown(deviceID uint) bool {
+// runSession keeps a started session running until its deadline or an early stop,
+// then turns the device OFF and closes the session. completedReason is recorded
+// when the deadline is reached.
+func (ds *DeviceService) runSession(ctx context.Context, active *activeActivation, req *models.ActivationRequest, device *models.Device, session *models.DeviceSession, startTime, deadline time.Time, completedReason string) {
 	ds.activeActivationsMu.Lock()
-	cancel, exists := ds.activeActivations[deviceID]
+	active.sessionID = session.ID
+	active.startedAt = startTime
+	active.deadline = deadline
 	ds.activeActivationsMu.Unlock()
-	if exists {
-		cancel()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	var cause *stopCause
+	for cause == nil {
+		select {
+		case <-timer.C:
+			cause = &stopCause{reason: completedReason}
+		case <-active.reschedule:
+			ds.activeActivationsMu.Lock()
+			deadline = active.deadline
+			ds.activeActivationsMu.Unlock()
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(deadline))
+			log.Printf("[State] Device %d will now remain ON until %s\n", req.DeviceID, deadline.Format("03:04 PM"))
+		case <-ctx.Done():
+			cause = stopCauseOf(ctx)
+			log.Printf("[Force] Activation for device %d stopped early (%s)", req.DeviceID, cause.reason)
+		}
+	}
+
+	// Handed off: the device stays ON and the next instance finishes the session
+	if cause.reason == causeHandoff {
+		handOffSession(session, deadline)
+		return
+	}
+
+	ds.closeSession(device, session, startTime, time.Now(), cause)
+	if cause.reason == completedReason {
+		setStatus(req, models.ActivationCompleted, cause.reason)
+	} else {
+		setStatus(req, models.ActivationCancelled, cause.reason)
+	}
+}
+
+// closeSession turns the device OFF and waits for it to confirm, then records the end of the session.
+func (ds *DeviceService) closeSession(device *models.Device, session *models.DeviceSession, startTime, shutdownTime time.Time, cause *stopCause) {
+	shutdownReason := cause.reason
+	actualDuration := shutdownTime.Sub(startTime)
+
+	// Turn the device OFF and wait for it to confirm; an unconfirmed OFF leaves it in FAULT
+	logTransitionError(device.ID, setDeviceState(device, models.StateStopping, stateChange{
+		cause:     shutdownReason,
+		actorID:   cause.userID,
+		sessionID: session.ID,
+		detail:    cause.detail,
+	}))
+	if ds.switchOffVerified(device, session.ID) {
+		if err := setDeviceState(device, restingState(device), stateChange{cause: models.CauseOffConfirmed, sessionID: session.ID}); err != nil {
+			log.Printf("[DB] Failed to turn OFF device %d: %v\n", device.ID, err)
+		}
+		log.Printf("[State] Device %d turned OFF at %s after %v\n", device.ID, shutdownTime.Format("03:04 PM"), actualDuration)
+	}
+
+	recordSessionEnd(device, session, startTime, shutdownTime, cause)
+}
+
+// recordSessionEnd logs the OFF, closes the session and charges its run time to the quota
+// ledger and energy accounting. It does not touch the device itself.
+func recordSessionEnd(device *models.Device, session *models.DeviceSession, startTime, shutdownTime time.Time, cause *stopCause) {
+	db := database.GetDB()
+	shutdownReason := cause.reason
+	var stoppedBy *uint
+	if cause.userID != 0 {
+		stoppedBy = &cause.userID
+	}
+	actualDuration := shutdownTime.Sub(startTime)
+
+	if err := db.Create(&models.DeviceLog{
+		State:     "OFF",
+		SessionID: session.ID,
+	}).Error; err != nil {
+		log.Printf("[Log] Failed to create OFF log for device %d\n", device.ID)
+	} else {
+		log.Printf("[Log] OFF state logged for device %d (was ON for %v, reason: %s)\n", device.ID, actualDuration, shutdownReason)
+	}
+
+	if err := db.Model(session).Updates(map[string]interface{}{
+		"ActiveUntil": shutdownTime.Format(time.RFC3339),
+		"EndedAt":     shutdownTime,
+		"Reason":      shutdownReason,
+		"StoppedBy":   stoppedBy,
+		"StopDetail":  cause.detail,
+	}).Error; err != nil {
+		log.Printf("[DB] Failed to update device session for device %d: %v\n", device.ID, err)
+	}
+
+	// Charge only the actual ON duration to the quota ledger
+	if err := RecordSessionUsage(session, startTime, shutdownTime); err != nil {
+		log.Printf("[Quota] Failed to record usage for session %d: %v\n", session.ID, err)
+	}
+
+	// Work out what the run cost, for monthly statements
+	if err := RecordSessionEnergy(device, session, startTime, shutdownTime); err != nil {
+		log.Printf("[Energy] %v\n", err)
+	}
+}
+
+// ForceShutdown cancels an active device activation (admin action).
+func (ds *DeviceService) ForceShutdown(deviceID, adminID uint) bool {
+	if ds.stopActivation(deviceID, 0, &stopCause{reason: models.ReasonForce, userID: adminID}) {
 		// Send push notification
 		SendDevicePushNotificationToAdmin(
 			deviceID,
@@ -291,15 +875,96 @@ func (ds *DeviceService) ForceShutdown(deviceID uint) bool {
 	return false
 }
 
-// HandleAcknowledgement is called when an ACK is received from a device.
-func (ds *DeviceService) HandleAcknowledgement(deviceID uint) {
-	ds.acknowledgmentChannelsMu.Lock()
-	acknowledgeChannel, exists := ds.acknowledgmentChannels[deviceID]
-	ds.acknowledgmentChannelsMu.Unlock()
-	if exists {
-		select {
-		case acknowledgeChannel <- struct{}{}:
-		default:
+// RetireDevice removes a device that is not running. Its queued requests are
+// cancelled and its schedules disabled; sessions and logs are kept for history.
+func (ds *DeviceService) RetireDevice(deviceID uint) error {
+	if ds.hasActivation(deviceID) {
+		return ErrDeviceBusy
+	}
+
+	db := database.GetDB()
+	var device models.Device
+	if err := db.First(&device, deviceID).Error; err != nil {
+		return ErrDeviceNotFound
+	}
+	if DeviceBusy(&device) {
+		return ErrDeviceBusy
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ActivationRequest{}).
+			Where("device_id = ? AND status = ?", deviceID, models.ActivationQueued).
+			Updates(map[string]interface{}{
+				"status":        models.ActivationCancelled,
+				"status_reason": "device retired",
+				"finished_at":   time.Now(),
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Schedule{}).
+			Where("device_id = ?", deviceID).
+			Update("enabled", false).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&device).Error; err != nil {
+			return err
 		}
+		log.Printf("[Device] Device %d (%s) retired", device.ID, device.Name)
+		return nil
+	})
+}
+
+var ErrActivationNotFound = errors.New("activation not found")
+var ErrActivationFinished = errors.New("activation has already finished")
+
+// CancelActivation lets a user withdraw their own queued request or stop their own running session.
+func (ds *DeviceService) CancelActivation(requestID, userID uint) (*models.ActivationRequest, error) {
+	db := database.GetDB()
+	var req models.ActivationRequest
+	if err := db.First(&req, requestID).Error; err != nil {
+		return nil, ErrActivationNotFound
+	}
+	if req.UserID != userID {
+		return nil, ErrNotSessionOwner
+	}
+
+	// A queued request is withdrawn in place, unless a lane picked it up meanwhile
+	if req.Status == models.ActivationQueued {
+		now := time.Now()
+		result := db.Model(&req).
+			Where("status = ?", models.ActivationQueued).
+			Updates(map[string]interface{}{
+				"status":        models.ActivationCancelled,
+				"status_reason": models.ReasonUserCancelled,
+				"finished_at":   now,
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			req.Status = models.ActivationCancelled
+			req.StatusReason = models.ReasonUserCancelled
+			req.FinishedAt = &now
+			log.Printf("[Queue] Request %d withdrawn by User %d", req.ID, userID)
+			return &req, nil
+		}
+		db.First(&req, requestID)
+	}
+
+	if req.IsFinal() {
+		return &req, ErrActivationFinished
+	}
+
+	// Dispatching or running: stop the lane handling it
+	if !ds.stopActivation(req.DeviceID, req.ID, &stopCause{reason: models.ReasonUserCancelled, userID: userID}) {
+		return &req, ErrActivationFinished
 	}
+	log.Printf("[Queue] Request %d on device %d stopped by User %d", req.ID, req.DeviceID, userID)
+
+	SendDevicePushNotificationToAdmin(
+		req.DeviceID,
+		fmt.Sprintf("Device %d was stopped at %s by its user", req.DeviceID, time.Now().Format("03:04 PM")),
+		map[string]string{"action": "off"},
+	)
+	return &req, nil
 }
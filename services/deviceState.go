@@ -0,0 +1,161 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// deviceTransitions lists the states each lifecycle state may move to.
+var deviceTransitions = map[string][]string{
+	models.StateOffline:     {models.StateIdle, models.StateStarting, models.StateStopping, models.StateMaintenance}, // Starting only with offline detection disabled
+	models.StateIdle:        {models.StateOffline, models.StateStarting, models.StateStopping, models.StateMaintenance},
+	models.StateStarting:    {models.StateRunning, models.StateIdle, models.StateOffline, models.StateStopping, models.StateMaintenance},
+	models.StateRunning:     {models.StateStopping},
+	models.StateStopping:    {models.StateIdle, models.StateOffline, models.StateFault, models.StateMaintenance},
+	models.StateFault:       {models.StateIdle, models.StateOffline, models.StateMaintenance},
+	models.StateMaintenance: {models.StateIdle, models.StateOffline},
+}
+
+// busyStates are the states in which the device may be drawing power.
+var busyStates = []string{models.StateStarting, models.StateRunning, models.StateStopping}
+
+// TransitionError reports a state change the lifecycle does not allow.
+type TransitionError struct {
+	DeviceID uint
+	From     string
+	To       string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("device %d cannot move from %s to %s", e.DeviceID, e.From, e.To)
+}
+
+// stateChange describes why a device is moving to a new state.
+type stateChange struct {
+	cause     string // One of the models.Cause constants or a session stop reason
+	actorID   uint   // User who caused it (0 for the system)
+	sessionID uint   // Session it belongs to (0 for none)
+	detail    string
+}
+
+// DeviceBusy reports whether the device may be drawing power.
+func DeviceBusy(device *models.Device) bool {
+	return containsState(busyStates, device.State)
+}
+
+// restingState is the state a device settles in once it is OFF: MAINTENANCE while a maintenance
+// window is open, otherwise IDLE, or OFFLINE if it is not reporting.
+func restingState(device *models.Device) string {
+	if UnderMaintenance(device.ID) {
+		return models.StateMaintenance
+	}
+	if DeviceIsOnline(device) {
+		return models.StateIdle
+	}
+	return models.StateOffline
+}
+
+// transitionAllowed reports whether the lifecycle allows moving from one state to another.
+func transitionAllowed(from, to string) bool {
+	for _, next := range deviceTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// setDeviceState moves the device to a new state and records the transition. The current
+// state is read under a row lock, so concurrent changes are serialised. When from is given,
+// the device must currently be in one of those states. Moving to the current state is a no-op.
+// Returns a *TransitionError if the change is not allowed; device.State is updated on success.
+func setDeviceState(device *models.Device, to string, change stateChange, from ...string) error {
+	var previous string
+	err := database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var current models.Device
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "state").First(&current, device.ID).Error; err != nil {
+			return err
+		}
+		previous = current.State
+		if previous == to {
+			return nil
+		}
+		if (len(from) > 0 && !containsState(from, previous)) || !transitionAllowed(previous, to) {
+			return &TransitionError{DeviceID: device.ID, From: previous, To: to}
+		}
+		if err := tx.Model(&current).Update("state", to).Error; err != nil {
+			return err
+		}
+		transition := models.DeviceStateTransition{
+			DeviceID:  device.ID,
+			FromState: previous,
+			ToState:   to,
+			Cause:     change.cause,
+			Detail:    change.detail,
+		}
+		if change.actorID != 0 {
+			transition.ActorID = &change.actorID
+		}
+		if change.sessionID != 0 {
+			transition.SessionID = &change.sessionID
+		}
+		return tx.Create(&transition).Error
+	})
+	if err != nil {
+		return err
+	}
+	device.State = to
+	if previous == to {
+		return nil
+	}
+
+	log.Printf("[State] Device %d: %s -> %s (%s)", device.ID, previous, to, change.cause)
+	BroadcastEvent(map[string]interface{}{
+		"type":       "device_state",
+		"device_id":  device.ID,
+		"from":       previous,
+		"state":      to,
+		"cause":      change.cause,
+		"changed_at": time.Now(),
+	})
+	return nil
+}
+
+// logTransitionError logs a failed state change.
+func logTransitionError(deviceID uint, err error) {
+	var invalid *TransitionError
+	switch {
+	case err == nil:
+	case errors.As(err, &invalid):
+		log.Printf("[State] Ignoring state change: %v", err)
+	default:
+		log.Printf("[DB] Failed to change state of device %d: %v", deviceID, err)
+	}
+}
+
+func containsState(states []string, state string) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceStateHistory returns the device's most recent state transitions, newest first.
+func DeviceStateHistory(deviceID uint, limit int) ([]models.DeviceStateTransition, error) {
+	var transitions []models.DeviceStateTransition
+	err := database.GetDB().
+		Where("device_id = ?", deviceID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&transitions).Error
+	return transitions, err
+}
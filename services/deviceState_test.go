@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+func TestTransitionAllowed(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{models.StateIdle, models.StateStarting, true},
+		{models.StateOffline, models.StateStarting, true},
+		{models.StateStarting, models.StateRunning, true},
+		{models.StateStarting, models.StateIdle, true},
+		{models.StateRunning, models.StateStopping, true},
+		{models.StateStopping, models.StateIdle, true},
+		{models.StateStopping, models.StateFault, true},
+		{models.StateFault, models.StateIdle, true},
+		{models.StateIdle, models.StateMaintenance, true},
+		{models.StateMaintenance, models.StateIdle, true},
+
+		// A running device must be stopped (and confirmed OFF) before anything else
+		{models.StateRunning, models.StateIdle, false},
+		{models.StateRunning, models.StateFault, false},
+		{models.StateRunning, models.StateMaintenance, false},
+		{models.StateIdle, models.StateRunning, false},
+		{models.StateFault, models.StateStarting, false},
+		{models.StateFault, models.StateRunning, false},
+		{models.StateMaintenance, models.StateStarting, false},
+		{models.StateStopping, models.StateRunning, false},
+		{"ON", models.StateIdle, false},
+		{models.StateIdle, "OFF", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := transitionAllowed(tt.from, tt.to); got != tt.want {
+				t.Errorf("transitionAllowed(%s, %s) = %t, want %t", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceTransitionsOnlyNameKnownStates(t *testing.T) {
+	for from, targets := range deviceTransitions {
+		for _, to := range targets {
+			if _, known := deviceTransitions[to]; !known {
+				t.Errorf("%s may move to unknown state %s", from, to)
+			}
+			if to == from {
+				t.Errorf("%s lists itself as a transition", from)
+			}
+		}
+	}
+}
+
+func TestDeviceBusy(t *testing.T) {
+	tests := []struct {
+		state string
+		want  bool
+	}{
+		{models.StateOffline, false},
+		{models.StateIdle, false},
+		{models.StateStarting, true},
+		{models.StateRunning, true},
+		{models.StateStopping, true},
+		{models.StateFault, false},
+		{models.StateMaintenance, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			if got := DeviceBusy(&models.Device{State: tt.state}); got != tt.want {
+				t.Errorf("DeviceBusy(%s) = %t, want %t", tt.state, got, tt.want)
+			}
+		})
+	}
+}
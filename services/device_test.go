@@ -0,0 +1,32 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+func TestAckBackoff(t *testing.T) {
+	tests := []struct {
+		name       string
+		ackTimeout time.Duration
+		want       []time.Duration
+	}{
+		{"default", 0, []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, 2 * time.Minute}},
+		{"device timeout", 5 * time.Second, []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second}},
+		{"above the cap from the start", 3 * time.Minute, []time.Duration{3 * time.Minute, 3 * time.Minute, 3 * time.Minute}},
+		{"reaches the cap", time.Minute, []time.Duration{time.Minute, 2 * time.Minute, 2 * time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeout := firstAckTimeout(&models.Device{AckTimeout: tt.ackTimeout})
+			for i, want := range tt.want {
+				if timeout != want {
+					t.Fatalf("attempt %d waits %v, want %v", i+1, timeout, want)
+				}
+				timeout = nextAckTimeout(timeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+// Where a session's energy figure comes from
+const (
+	EnergyFromTelemetry = "telemetry" // Integrated from voltage and current samples
+	EnergyFromRated     = "rated"     // Rated kW times actual runtime
+)
+
+// powerSegment is a stretch of time over which the device drew a constant power.
+type powerSegment struct {
+	from, to time.Time
+	kW       float64
+}
+
+// Tariff prices energy with a peak and an off-peak rate.
+type Tariff struct {
+	PeakRate    float64
+	OffPeakRate float64
+	PeakStart   time.Duration // Offset of the peak window from local midnight
+	PeakEnd     time.Duration // May be before PeakStart for a window that crosses midnight
+	HasPeak     bool
+	Currency    string
+	loc         *time.Location
+}
+
+// LoadTariff reads the tariff from the configuration.
+func LoadTariff() Tariff {
+	cfg := Settings()
+	tariff := Tariff{
+		PeakRate:    cfg.TariffPeakRate,
+		OffPeakRate: cfg.TariffOffPeakRate,
+		Currency:    cfg.TariffCurrency,
+		loc:         cfg.Location(),
+	}
+	tariff.PeakStart, tariff.PeakEnd, tariff.HasPeak = parsePeakHours(cfg.TariffPeakHours)
+	return tariff
+}
+
+// parsePeakHours parses a "HH:MM-HH:MM" window into offsets from midnight.
+func parsePeakHours(value string) (time.Duration, time.Duration, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	start, errStart := parseClock(parts[0])
+	end, errEnd := parseClock(parts[1])
+	if errStart != nil || errEnd != nil {
+		return 0, 0, false
+	}
+	return start, end, start != end
+}
+
+// peakOverlap returns how much of [from, to) falls inside the daily peak window.
+func (t Tariff) peakOverlap(from, to time.Time) time.Duration {
+	if !t.HasPeak || !to.After(from) {
+		return 0
+	}
+	var overlap time.Duration
+	// Start a day early to catch a window that began the previous evening
+	for day := localMidnight(from, t.loc).AddDate(0, 0, -1); day.Before(to); day = day.AddDate(0, 0, 1) {
+		windowStart := day.Add(t.PeakStart)
+		windowEnd := day.Add(t.PeakEnd)
+		if t.PeakEnd < t.PeakStart {
+			windowEnd = windowEnd.Add(24 * time.Hour)
+		}
+		start, end := windowStart, windowEnd
+		if from.After(start) {
+			start = from
+		}
+		if to.Before(end) {
+			end = to
+		}
+		if end.After(start) {
+			overlap += end.Sub(start)
+		}
+	}
+	return overlap
+}
+
+// price returns the energy (kWh) and cost of the given segments.
+func (t Tariff) price(segments []powerSegment) (kWh, cost float64) {
+	for _, segment := range segments {
+		peak := t.peakOverlap(segment.from, segment.to)
+		offPeak := segment.to.Sub(segment.from) - peak
+		peakKWh := segment.kW * peak.Hours()
+		offPeakKWh := segment.kW * offPeak.Hours()
+		kWh += peakKWh + offPeakKWh
+		cost += peakKWh*t.PeakRate + offPeakKWh*t.OffPeakRate
+	}
+	return kWh, cost
+}
+
+// telemetrySegments builds power segments from the voltage and current samples recorded
+// between start and end. It needs at least two samples to measure anything.
+func telemetrySegments(deviceID uint, start, end time.Time) []powerSegment {
+	var samples []models.DeviceTelemetry
+	database.GetDB().
+		Where("device_id = ? AND recorded_at BETWEEN ? AND ? AND current IS NOT NULL AND voltage IS NOT NULL", deviceID, start, end).
+		Order("recorded_at").
+		Find(&samples)
+	if len(samples) < 2 {
+		return nil
+	}
+
+	kW := func(sample *models.DeviceTelemetry) float64 {
+		return *sample.Voltage * *sample.Current / 1000
+	}
+	// Hold the first and last readings out to the session boundaries
+	segments := []powerSegment{{from: start, to: samples[0].RecordedAt, kW: kW(&samples[0])}}
+	for i := 1; i < len(samples); i++ {
+		segments = append(segments, powerSegment{
+			from: samples[i-1].RecordedAt,
+			to:   samples[i].RecordedAt,
+			kW:   (kW(&samples[i-1]) + kW(&samples[i])) / 2,
+		})
+	}
+	last := &samples[len(samples)-1]
+	return append(segments, powerSegment{from: last.RecordedAt, to: end, kW: kW(last)})
+}
+
+// RecordSessionEnergy works out the energy and cost of a closed session and stores them on it.
+// Measured telemetry is preferred; otherwise the device's rated power is used. Sessions of
+// devices with neither are left without an energy figure.
+func RecordSessionEnergy(device *models.Device, session *models.DeviceSession, start, end time.Time) error {
+	source := EnergyFromTelemetry
+	segments := telemetrySegments(device.ID, start, end)
+	if segments == nil {
+		if device.RatedPowerKW == nil {
+			return nil
+		}
+		source = EnergyFromRated
+		segments = []powerSegment{{from: start, to: end, kW: *device.RatedPowerKW}}
+	}
+
+	kWh, cost := LoadTariff().price(segments)
+	session.EnergyKWh = &kWh
+	session.EnergySource = source
+	session.Cost = &cost
+	if err := database.GetDB().Model(session).Updates(map[string]interface{}{
+		"EnergyKWh":    kWh,
+		"EnergySource": source,
+		"Cost":         cost,
+	}).Error; err != nil {
+		return fmt.Errorf("saving energy of session %d: %w", session.ID, err)
+	}
+	return nil
+}
+
+// CostStatement is one user's usage for a month.
+type CostStatement struct {
+	UserID    uint    `json:"user_id"`
+	Email     string  `json:"email"`
+	Sessions  int64   `json:"sessions"`
+	Runtime   float64 `json:"runtime_minutes"` // Minutes the device ran for the user
+	EnergyKWh float64 `json:"energy_kwh" gorm:"column:energy_kwh"`
+	Cost      float64 `json:"cost"`
+}
+
+// MonthlyCostStatements totals the sessions that ended in the given local month per user.
+// userID restricts the result to one user and deviceID to one device (0 for all).
+func MonthlyCostStatements(month time.Time, userID, deviceID uint) ([]CostStatement, error) {
+	loc := Settings().Location()
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 1, 0)
+
+	query := database.GetDB().Table("device_sessions").
+		Select(`device_sessions.user_id,
+			users.email,
+			COUNT(*) AS sessions,
+			COALESCE(SUM(EXTRACT(EPOCH FROM (device_sessions.ended_at - device_sessions.started_at)) / 60), 0) AS runtime,
+			COALESCE(SUM(device_sessions.energy_kwh), 0) AS energy_kwh,
+			COALESCE(SUM(device_sessions.cost), 0) AS cost`).
+		Joins("JOIN users ON users.id = device_sessions.user_id").
+		Where("device_sessions.deleted_at IS NULL AND device_sessions.ended_at >= ? AND device_sessions.ended_at < ?", from, to).
+		Group("device_sessions.user_id, users.email").
+		Order("device_sessions.user_id")
+	if userID != 0 {
+		query = query.Where("device_sessions.user_id = ?", userID)
+	}
+	if deviceID != 0 {
+		query = query.Where("device_sessions.device_id = ?", deviceID)
+	}
+
+	var statements []CostStatement
+	if err := query.Scan(&statements).Error; err != nil {
+		return nil, err
+	}
+	return statements, nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParsePeakHours(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end time.Duration
+		ok         bool
+	}{
+		{"17:00-22:00", 17 * time.Hour, 22 * time.Hour, true},
+		{" 22:30 - 06:00 ", 22*time.Hour + 30*time.Minute, 6 * time.Hour, true},
+		{"", 0, 0, false},
+		{"17:00", 0, 0, false},
+		{"17:00-25:00", 0, 0, false},
+		{"18:00-18:00", 18 * time.Hour, 18 * time.Hour, false}, // Empty window: no peak
+	}
+	for _, tt := range tests {
+		start, end, ok := parsePeakHours(tt.value)
+		if ok != tt.ok || (ok && (start != tt.start || end != tt.end)) {
+			t.Errorf("parsePeakHours(%q) = (%v, %v, %t), want (%v, %v, %t)", tt.value, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestPeakOverlap(t *testing.T) {
+	evening := Tariff{PeakStart: 17 * time.Hour, PeakEnd: 22 * time.Hour, HasPeak: true, loc: time.UTC}
+	overnight := Tariff{PeakStart: 22 * time.Hour, PeakEnd: 6 * time.Hour, HasPeak: true, loc: time.UTC}
+	oct16 := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		tariff   Tariff
+		from, to time.Duration // Offsets from midnight on Oct 16
+		want     time.Duration
+	}{
+		{"before the peak", evening, 9 * time.Hour, 11 * time.Hour, 0},
+		{"inside the peak", evening, 18 * time.Hour, 19 * time.Hour, time.Hour},
+		{"runs into the peak", evening, 16 * time.Hour, 17*time.Hour + 30*time.Minute, 30 * time.Minute},
+		{"runs out of the peak", evening, 21 * time.Hour, 23 * time.Hour, time.Hour},
+		{"spans the whole peak", evening, 12 * time.Hour, 23 * time.Hour, 5 * time.Hour},
+		{"spans two peaks", evening, 20 * time.Hour, 46 * time.Hour, 2*time.Hour + 5*time.Hour},
+		{"empty run", evening, 18 * time.Hour, 18 * time.Hour, 0},
+		{"reversed run", evening, 19 * time.Hour, 18 * time.Hour, 0},
+		{"no peak", Tariff{loc: time.UTC}, 18 * time.Hour, 19 * time.Hour, 0},
+		{"overnight peak after midnight", overnight, 2 * time.Hour, 8 * time.Hour, 4 * time.Hour},
+		{"overnight peak across midnight", overnight, 23 * time.Hour, 25 * time.Hour, 2 * time.Hour},
+		{"between overnight peaks", overnight, 7 * time.Hour, 21 * time.Hour, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.tariff.peakOverlap(oct16.Add(tt.from), oct16.Add(tt.to))
+			if got != tt.want {
+				t.Errorf("peakOverlap = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTariffPrice(t *testing.T) {
+	tariff := Tariff{PeakRate: 60, OffPeakRate: 40, PeakStart: 17 * time.Hour, PeakEnd: 22 * time.Hour, HasPeak: true, loc: time.UTC}
+	base := time.Date(2026, 10, 16, 16, 0, 0, 0, time.UTC)
+
+	// 16:00-18:00 at 2 kW: one off-peak hour and one peak hour; 18:00-18:30 at 1 kW, all peak
+	segments := []powerSegment{
+		{from: base, to: base.Add(2 * time.Hour), kW: 2},
+		{from: base.Add(2 * time.Hour), to: base.Add(150 * time.Minute), kW: 1},
+	}
+	kWh, cost := tariff.price(segments)
+	if math.Abs(kWh-4.5) > 1e-9 {
+		t.Errorf("kWh = %v, want 4.5", kWh)
+	}
+	if want := 2*40.0 + 2*60.0 + 0.5*60.0; math.Abs(cost-want) > 1e-9 {
+		t.Errorf("cost = %v, want %v", cost, want)
+	}
+}
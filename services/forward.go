@@ -0,0 +1,209 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+const (
+	controlPollInterval = time.Second      // How often the leader looks for forwarded commands
+	controlCommandTTL   = 30 * time.Second // Older commands are dropped rather than applied late
+)
+
+// activationSnapshot is what AdjustActivation needs to know about a running session.
+type activationSnapshot struct {
+	sessionID uint
+	ownerID   uint
+	startedAt time.Time
+	deadline  time.Time
+	device    models.Device
+}
+
+// hasActivation reports whether the device has an activation being dispatched or running.
+// Followers cannot see the leader's lanes, so they read the request queue instead.
+func (ds *DeviceService) hasActivation(deviceID uint) bool {
+	if IsLeader() {
+		ds.activeActivationsMu.Lock()
+		_, exists := ds.activeActivations[deviceID]
+		ds.activeActivationsMu.Unlock()
+		return exists
+	}
+	var count int64
+	database.GetDB().Model(&models.ActivationRequest{}).
+		Where("device_id = ? AND status IN ?", deviceID, []string{models.ActivationDispatching, models.ActivationRunning}).
+		Count(&count)
+	return count > 0
+}
+
+// stopActivation stops the device's activation (only request requestID, if non-zero).
+// On a follower the stop is forwarded to the leader. Returns false if nothing was running.
+func (ds *DeviceService) stopActivation(deviceID, requestID uint, cause *stopCause) bool {
+	if !IsLeader() {
+		return forwardStop(deviceID, requestID, cause)
+	}
+	ds.activeActivationsMu.Lock()
+	active, exists := ds.activeActivations[deviceID]
+	if exists && requestID != 0 && active.requestID != requestID {
+		exists = false
+	}
+	if exists {
+		active.stopping = true
+	}
+	ds.activeActivationsMu.Unlock()
+	if exists {
+		active.cancel(cause)
+	}
+	return exists
+}
+
+// runningActivation returns the device's running session, read from the lane on the
+// leader and from the database on a follower.
+func (ds *DeviceService) runningActivation(deviceID uint) (*activationSnapshot, bool) {
+	if IsLeader() {
+		ds.activeActivationsMu.Lock()
+		defer ds.activeActivationsMu.Unlock()
+		active, exists := ds.activeActivations[deviceID]
+		if !exists || active.sessionID == 0 {
+			return nil, false
+		}
+		return &activationSnapshot{
+			sessionID: active.sessionID,
+			ownerID:   active.userID,
+			startedAt: active.startedAt,
+			deadline:  active.deadline,
+			device:    active.device,
+		}, true
+	}
+
+	db := database.GetDB()
+	var req models.ActivationRequest
+	if err := db.Where("device_id = ? AND status = ? AND session_id IS NOT NULL", deviceID, models.ActivationRunning).
+		First(&req).Error; err != nil {
+		return nil, false
+	}
+	var session models.DeviceSession
+	if err := db.Where("id = ? AND ended_at IS NULL", *req.SessionID).First(&session).Error; err != nil {
+		return nil, false
+	}
+	snapshot := &activationSnapshot{
+		sessionID: session.ID,
+		ownerID:   session.UserID,
+		startedAt: session.StartedAt,
+		deadline:  session.ActiveUntil,
+	}
+	if err := db.First(&snapshot.device, deviceID).Error; err != nil {
+		return nil, false
+	}
+	return snapshot, true
+}
+
+// rescheduleActivation hands a new end time to the lane running the session, unless it ended meanwhile.
+// On a follower the change is forwarded to the leader.
+func (ds *DeviceService) rescheduleActivation(deviceID, sessionID uint, deadline time.Time) {
+	if !IsLeader() {
+		forwardControl(&models.ControlCommand{
+			Command:   models.ControlReschedule,
+			DeviceID:  deviceID,
+			SessionID: &sessionID,
+			Deadline:  &deadline,
+		})
+		return
+	}
+	ds.activeActivationsMu.Lock()
+	defer ds.activeActivationsMu.Unlock()
+	if active, ok := ds.activeActivations[deviceID]; ok && active.sessionID == sessionID {
+		active.deadline = deadline
+		select {
+		case active.reschedule <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// forwardStop asks the leader to stop an activation the database shows as dispatching or running.
+func forwardStop(deviceID, requestID uint, cause *stopCause) bool {
+	query := database.GetDB().Model(&models.ActivationRequest{}).
+		Where("device_id = ? AND status IN ?", deviceID, []string{models.ActivationDispatching, models.ActivationRunning})
+	if requestID != 0 {
+		query = query.Where("id = ?", requestID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil || count == 0 {
+		return false
+	}
+	command := &models.ControlCommand{
+		Command:  models.ControlStop,
+		DeviceID: deviceID,
+		Reason:   cause.reason,
+		UserID:   cause.userID,
+		Detail:   cause.detail,
+	}
+	if requestID != 0 {
+		command.ActivationRequestID = &requestID
+	}
+	return forwardControl(command)
+}
+
+// forwardControl stores a command for the leader to apply.
+func forwardControl(command *models.ControlCommand) bool {
+	if err := database.GetDB().Create(command).Error; err != nil {
+		log.Printf("[Leader] Failed to forward %s for device %d: %v", command.Command, command.DeviceID, err)
+		return false
+	}
+	log.Printf("[Leader] Forwarded %s for device %d to the leader", command.Command, command.DeviceID)
+	return true
+}
+
+// controlLoop applies the commands followers forward, until the service shuts down.
+func (ds *DeviceService) controlLoop() {
+	ticker := time.NewTicker(controlPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ds.base.Done():
+			return
+		case <-ticker.C:
+		}
+		ds.applyForwardedCommands(time.Now())
+	}
+}
+
+// applyForwardedCommands applies every unprocessed command, dropping those too old to act on.
+func (ds *DeviceService) applyForwardedCommands(now time.Time) {
+	db := database.GetDB()
+	var commands []models.ControlCommand
+	if err := db.Where("processed_at IS NULL").Order("id").Find(&commands).Error; err != nil {
+		log.Printf("[Leader] Failed to fetch forwarded commands: %v", err)
+		return
+	}
+	for i := range commands {
+		command := &commands[i]
+		outcome := "applied"
+		switch {
+		case now.Sub(command.CreatedAt) > controlCommandTTL:
+			outcome = "expired"
+		case command.Command == models.ControlStop:
+			var requestID uint
+			if command.ActivationRequestID != nil {
+				requestID = *command.ActivationRequestID
+			}
+			if !ds.stopActivation(command.DeviceID, requestID, &stopCause{reason: command.Reason, userID: command.UserID, detail: command.Detail}) {
+				outcome = "not running"
+			}
+		case command.Command == models.ControlReschedule && command.SessionID != nil && command.Deadline != nil:
+			ds.rescheduleActivation(command.DeviceID, *command.SessionID, *command.Deadline)
+		default:
+			outcome = "invalid"
+		}
+		if err := db.Model(command).Updates(map[string]interface{}{
+			"processed_at": now,
+			"outcome":      outcome,
+		}).Error; err != nil {
+			log.Printf("[Leader] Failed to mark command %d processed: %v", command.ID, err)
+		}
+		log.Printf("[Leader] Forwarded %s for device %d: %s", command.Command, command.DeviceID, outcome)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+const (
+	interlockHoldReason      = "waiting for interlock: " // Status reason of requests held behind a group member
+	interlockRecheckInterval = time.Minute               // How long a held request waits if its group is not released first
+)
+
+// interlockHoldingStates are the states in which a device keeps its interlock group: the busy
+// states, and FAULT, since a device that never confirmed OFF may still be running.
+var interlockHoldingStates = append(append([]string{}, busyStates...), models.StateFault)
+
+// InterlockError reports that another member of the device's interlock group is running.
+type InterlockError struct {
+	Group        string
+	DeviceID     uint
+	ConflictID   uint   // The group member that is ON
+	ConflictName string // Its name, for messages
+}
+
+func (e *InterlockError) Error() string {
+	return fmt.Sprintf("device %d is interlocked with %q (device %d, group %q), which is ON", e.DeviceID, e.ConflictName, e.ConflictID, e.Group)
+}
+
+// interlockHolderLocked returns the other member of the device's interlock group that a lane
+// of this process holds it for, if any. The caller must hold interlocksMu.
+func (ds *DeviceService) interlockHolderLocked(device *models.Device) (uint, bool) {
+	if device.InterlockGroup == "" {
+		return 0, false
+	}
+	holderID, held := ds.interlocks[device.InterlockGroup]
+	return holderID, held && holderID != device.ID
+}
+
+// interlockConflictLocked returns the running member of the device's interlock group, if any.
+// The caller must hold interlocksMu.
+func (ds *DeviceService) interlockConflictLocked(device *models.Device) *InterlockError {
+	if device.InterlockGroup == "" {
+		return nil
+	}
+	conflictID, held := ds.interlockHolderLocked(device)
+	if !held {
+		// Also catch members that are ON without a lane holding the group, e.g. switched on before a restart
+		// or left in FAULT until the fault is resolved
+		var running models.Device
+		err := database.GetDB().
+			Where("interlock_group = ? AND id <> ? AND state IN ?", device.InterlockGroup, device.ID, interlockHoldingStates).
+			First(&running).Error
+		if err != nil {
+			return nil
+		}
+		return &InterlockError{Group: device.InterlockGroup, DeviceID: device.ID, ConflictID: running.ID, ConflictName: running.Name}
+	}
+	conflict := &InterlockError{Group: device.InterlockGroup, DeviceID: device.ID, ConflictID: conflictID}
+	var other models.Device
+	if err := database.GetDB().Select("name").First(&other, conflictID).Error; err == nil {
+		conflict.ConflictName = other.Name
+	}
+	return conflict
+}
+
+// InterlockConflict reports whether another member of the device's interlock group is running.
+func (ds *DeviceService) InterlockConflict(device *models.Device) *InterlockError {
+	ds.interlocksMu.Lock()
+	defer ds.interlocksMu.Unlock()
+	return ds.interlockConflictLocked(device)
+}
+
+// acquireInterlock claims the device's interlock group for it, unless another member is running.
+func (ds *DeviceService) acquireInterlock(device *models.Device) *InterlockError {
+	if device.InterlockGroup == "" {
+		return nil
+	}
+	ds.interlocksMu.Lock()
+	defer ds.interlocksMu.Unlock()
+	if conflict := ds.interlockConflictLocked(device); conflict != nil {
+		return conflict
+	}
+	ds.interlocks[device.InterlockGroup] = device.ID
+	return nil
+}
+
+// releaseInterlock frees the device's interlock group and wakes any requests held behind it.
+func (ds *DeviceService) releaseInterlock(device *models.Device) {
+	if device.InterlockGroup == "" {
+		return
+	}
+	ds.interlocksMu.Lock()
+	if ds.interlocks[device.InterlockGroup] == device.ID {
+		delete(ds.interlocks, device.InterlockGroup)
+	}
+	ds.interlocksMu.Unlock()
+	log.Printf("[Interlock] Device %d released group %q", device.ID, device.InterlockGroup)
+
+	// Requests held behind the group may try again straight away
+	if err := database.GetDB().Model(&models.ActivationRequest{}).
+		Where("status = ? AND status_reason LIKE ?", models.ActivationQueued, interlockHoldReason+"%").
+		Where("device_id IN (SELECT id FROM devices WHERE interlock_group = ?)", device.InterlockGroup).
+		Update("not_before", nil).Error; err != nil {
+		log.Printf("[Interlock] Failed to wake requests held behind group %q: %v", device.InterlockGroup, err)
+	}
+	ds.notify()
+}
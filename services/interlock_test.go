@@ -0,0 +1,66 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+func TestInterlockHolder(t *testing.T) {
+	device := func(id uint, group string) *models.Device {
+		d := &models.Device{InterlockGroup: group}
+		d.ID = id
+		return d
+	}
+
+	tests := []struct {
+		name       string
+		interlocks map[string]uint
+		device     *models.Device
+		wantHolder uint
+		wantHeld   bool
+	}{
+		{"no group", map[string]uint{"": 2}, device(1, ""), 0, false},
+		{"group free", map[string]uint{}, device(1, "transformer-1"), 0, false},
+		{"held by another member", map[string]uint{"transformer-1": 2}, device(1, "transformer-1"), 2, true},
+		{"held by the device itself", map[string]uint{"transformer-1": 1}, device(1, "transformer-1"), 1, false},
+		{"other group held", map[string]uint{"transformer-2": 2}, device(1, "transformer-1"), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &DeviceService{interlocks: tt.interlocks}
+			holder, held := ds.interlockHolderLocked(tt.device)
+			if held != tt.wantHeld || (held && holder != tt.wantHolder) {
+				t.Errorf("interlockHolderLocked = (%d, %t), want (%d, %t)", holder, held, tt.wantHolder, tt.wantHeld)
+			}
+		})
+	}
+}
+
+func TestInterlockErrorMessage(t *testing.T) {
+	err := &InterlockError{Group: "transformer-1", DeviceID: 1, ConflictID: 2, ConflictName: "Borewell"}
+	want := `device 1 is interlocked with "Borewell" (device 2, group "transformer-1"), which is ON`
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestInterlockHoldingStates(t *testing.T) {
+	tests := []struct {
+		state string
+		want  bool
+	}{
+		{models.StateOffline, false},
+		{models.StateIdle, false},
+		{models.StateStarting, true},
+		{models.StateRunning, true},
+		{models.StateStopping, true},
+		{models.StateFault, true}, // May still be running until the fault is resolved
+		{models.StateMaintenance, false},
+	}
+	for _, tt := range tests {
+		if got := containsState(interlockHoldingStates, tt.state); got != tt.want {
+			t.Errorf("%s holds the interlock group = %t, want %t", tt.state, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+)
+
+// leaderCheckInterval is how often the leader checks that its lock connection is still alive.
+const leaderCheckInterval = 5 * time.Second
+
+// leader is set while this replica holds the leader lock.
+var leader atomic.Bool
+
+// IsLeader reports whether this replica controls devices. Followers serve HTTP and
+// WebSocket clients and forward work to the leader through the database.
+func IsLeader() bool {
+	return leader.Load()
+}
+
+// LeaderElector holds a Postgres session-level advisory lock; whoever holds it is the leader.
+type LeaderElector struct {
+	key  int64
+	mu   sync.Mutex
+	conn *sql.Conn // Dedicated connection the lock lives on
+}
+
+// NewLeaderElector returns an elector for the given advisory lock key.
+func NewLeaderElector(key int64) *LeaderElector {
+	return &LeaderElector{key: key}
+}
+
+// Run tries to take the leader lock every retry interval until it succeeds or ctx is done,
+// then calls onElected once. Losing the lock ends the process: this replica can no longer
+// tell whether another one has started controlling devices.
+func (e *LeaderElector) Run(ctx context.Context, retry time.Duration, onElected func()) {
+	for {
+		acquired, err := e.tryAcquire(ctx)
+		if err != nil {
+			log.Printf("[Leader] Failed to try the leader lock: %v", err)
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+	}
+
+	leader.Store(true)
+	log.Printf("[Leader] This replica is the leader (lock %d)", e.key)
+	onElected()
+
+	ticker := time.NewTicker(leaderCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		e.mu.Lock()
+		conn := e.conn
+		e.mu.Unlock()
+		if conn == nil {
+			return
+		}
+		if err := conn.PingContext(ctx); err != nil && ctx.Err() == nil {
+			log.Fatalf("[Leader] Lost the connection holding the leader lock: %v", err)
+		}
+	}
+}
+
+// tryAcquire takes the advisory lock on a dedicated connection if no other replica holds it.
+func (e *LeaderElector) tryAcquire(ctx context.Context) (bool, error) {
+	sqlDB, err := database.GetDB().DB()
+	if err != nil {
+		return false, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.key).Scan(&acquired); err != nil || !acquired {
+		conn.Close()
+		return false, err
+	}
+	e.mu.Lock()
+	e.conn = conn
+	e.mu.Unlock()
+	return true, nil
+}
+
+// Release gives up leadership, unlocking before the connection goes back to the pool.
+func (e *LeaderElector) Release() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		return
+	}
+	leader.Store(false)
+	if _, err := e.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", e.key); err != nil {
+		log.Printf("[Leader] Failed to release the leader lock: %v", err)
+	}
+	e.conn.Close()
+	e.conn = nil
+	log.Println("[Leader] Released the leader lock")
+}
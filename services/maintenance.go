@@ -0,0 +1,145 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"gorm.io/gorm"
+)
+
+var ErrDeviceInMaintenance = errors.New("device is under maintenance")
+var ErrNotInMaintenance = errors.New("device is not under maintenance")
+
+// UnderMaintenance reports whether the device has an open maintenance window.
+func UnderMaintenance(deviceID uint) bool {
+	var count int64
+	database.GetDB().Model(&models.MaintenanceWindow{}).
+		Where("device_id = ? AND ended_at IS NULL", deviceID).
+		Count(&count)
+	return count > 0
+}
+
+// StartMaintenance takes the device out of service. Its queued requests are cancelled and
+// a running activation is stopped; the device enters MAINTENANCE once it is OFF.
+func (ds *DeviceService) StartMaintenance(deviceID, adminID uint, note string) (*models.MaintenanceWindow, error) {
+	db := database.GetDB()
+	var device models.Device
+	if err := db.First(&device, deviceID).Error; err != nil {
+		return nil, ErrDeviceNotFound
+	}
+
+	window := models.MaintenanceWindow{
+		DeviceID:  deviceID,
+		Note:      note,
+		StartedBy: adminID,
+		StartedAt: time.Now(),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var open int64
+		if err := tx.Model(&models.MaintenanceWindow{}).Where("device_id = ? AND ended_at IS NULL", deviceID).Count(&open).Error; err != nil {
+			return err
+		}
+		if open > 0 {
+			return ErrDeviceInMaintenance
+		}
+		if err := tx.Create(&window).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.ActivationRequest{}).
+			Where("device_id = ? AND status = ?", deviceID, models.ActivationQueued).
+			Updates(map[string]interface{}{
+				"status":        models.ActivationCancelled,
+				"status_reason": ErrDeviceInMaintenance.Error(),
+				"finished_at":   time.Now(),
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[Maintenance] Device %d put under maintenance by admin %d", deviceID, adminID)
+
+	// A busy device moves to MAINTENANCE once its activation has stopped and it is OFF
+	running := ds.stopActivation(deviceID, 0, &stopCause{reason: models.ReasonMaintenance, userID: adminID, detail: note})
+	logTransitionError(deviceID, setDeviceState(&device, models.StateMaintenance, stateChange{
+		cause:   models.CauseMaintenance,
+		actorID: adminID,
+		detail:  note,
+	}, models.StateIdle, models.StateOffline, models.StateFault))
+
+	BroadcastEvent(map[string]interface{}{
+		"type":        "device_maintenance",
+		"device_id":   deviceID,
+		"maintenance": true,
+		"note":        note,
+	})
+	message := fmt.Sprintf("Device %d is under maintenance and cannot be started.", deviceID)
+	if running {
+		message = fmt.Sprintf("Device %d was switched off for maintenance and cannot be started until it is finished.", deviceID)
+	}
+	SendDevicePushNotificationToAll(
+		deviceID,
+		message,
+		map[string]string{
+			"device_id": fmt.Sprintf("%d", deviceID),
+			"action":    "maintenance",
+		},
+	)
+	return &window, nil
+}
+
+// EndMaintenance closes the device's maintenance window and returns it to service.
+func (ds *DeviceService) EndMaintenance(deviceID, adminID uint) (*models.MaintenanceWindow, error) {
+	db := database.GetDB()
+	var window models.MaintenanceWindow
+	if err := db.Where("device_id = ? AND ended_at IS NULL", deviceID).First(&window).Error; err != nil {
+		return nil, ErrNotInMaintenance
+	}
+	now := time.Now()
+	if err := db.Model(&window).Updates(map[string]interface{}{
+		"ended_by": adminID,
+		"ended_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	window.EndedBy = &adminID
+	window.EndedAt = &now
+	log.Printf("[Maintenance] Device %d returned to service by admin %d", deviceID, adminID)
+
+	var device models.Device
+	if err := db.First(&device, deviceID).Error; err == nil {
+		logTransitionError(deviceID, setDeviceState(&device, restingState(&device), stateChange{
+			cause:   models.CauseMaintenance,
+			actorID: adminID,
+		}, models.StateMaintenance))
+	}
+
+	BroadcastEvent(map[string]interface{}{
+		"type":        "device_maintenance",
+		"device_id":   deviceID,
+		"maintenance": false,
+	})
+	SendDevicePushNotificationToAll(
+		deviceID,
+		fmt.Sprintf("Maintenance on device %d is finished. It can be started again.", deviceID),
+		map[string]string{
+			"device_id": fmt.Sprintf("%d", deviceID),
+			"action":    "maintenance_ended",
+		},
+	)
+	return &window, nil
+}
+
+// MaintenanceHistory returns the device's maintenance windows, newest first.
+func MaintenanceHistory(deviceID uint, limit int) ([]models.MaintenanceWindow, error) {
+	var windows []models.MaintenanceWindow
+	err := database.GetDB().
+		Where("device_id = ?", deviceID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&windows).Error
+	return windows, err
+}
@@ -52,6 +52,12 @@ func Subscribe(topic string, callback mqttlib.MessageHandler) error { // Subscri
 	return nil // Success
 }
 
+func Disconnect() { // Disconnects from the broker once in-flight messages are delivered
+	if Client != nil && Client.IsConnected() {
+		Client.Disconnect(250) // Allow 250 ms to finish pending work
+	}
+}
+
 func Publish(topic string, payload interface{}, qos byte, retain bool) error { // Publish a message to a topic
 	token := Client.Publish(topic, qos, retain, payload) // Publish message
 	token.Wait()                                         // Wait for publish to complete
@@ -1,9 +1,50 @@
 package services
 
+import (
+	"strconv"
+	"strings"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
 const (
-	MQTTTopicDeviceControl  = "device/control"
+	MQTTTopicDeviceControl  = "device/control" // legacy topic shared by all single-topic firmware
 	MQTTTopicDeviceStatus   = "device/+/status"
 	MQTTTopicDeviceSpecific = "device/%d/status" // for fmt.Sprintf
 	// Add more topics as needed
-	MQTTAckTopic = "device/+/ack" // for acknowledgment messages
+	MQTTAckTopic             = "device/+/ack"       // for acknowledgment messages
+	MQTTTopicDeviceHeartbeat = "device/+/heartbeat" // periodic liveness messages
+
+	MQTTTopicProvisionRequest  = "provision/request"     // devices redeem claim codes here
+	MQTTTopicProvisionResponse = "provision/%s/response" // reply topic, keyed by the device's nonce (for fmt.Sprintf)
+
+	// MQTTTopicDeviceControlTemplate is the default per-device control topic.
+	// The "{id}" placeholder is replaced with the device ID.
+	MQTTTopicDeviceControlTemplate = "device/{id}/control"
 )
+
+// ParseDeviceTopicID extracts the device ID from a "device/{id}/<suffix>" topic.
+func ParseDeviceTopicID(topic string) (uint, bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 3 || parts[0] != "device" {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// DeviceControlTopic returns the topic control commands for the given device are published to.
+// Devices flagged as legacy keep using the shared "device/control" topic.
+func DeviceControlTopic(device *models.Device) string {
+	if device.LegacyControl {
+		return MQTTTopicDeviceControl
+	}
+	template := device.ControlTopic
+	if template == "" {
+		template = MQTTTopicDeviceControlTemplate
+	}
+	return strings.ReplaceAll(template, "{id}", strconv.FormatUint(uint64(device.ID), 10))
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+func TestDeviceControlTopic(t *testing.T) {
+	tests := []struct {
+		name   string
+		device models.Device
+		want   string
+	}{
+		{"default template", models.Device{}, "device/7/control"},
+		{"legacy firmware", models.Device{LegacyControl: true}, "device/control"},
+		{"legacy wins over a custom topic", models.Device{LegacyControl: true, ControlTopic: "farm/{id}/cmd"}, "device/control"},
+		{"custom template", models.Device{ControlTopic: "farm/{id}/cmd"}, "farm/7/cmd"},
+		{"custom topic without placeholder", models.Device{ControlTopic: "borewell/relay"}, "borewell/relay"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := tt.device
+			device.ID = 7
+			if got := DeviceControlTopic(&device); got != tt.want {
+				t.Errorf("DeviceControlTopic = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDeviceTopicID(t *testing.T) {
+	tests := []struct {
+		topic  string
+		wantID uint
+		wantOK bool
+	}{
+		{"device/12/ack", 12, true},
+		{"device/12/status", 12, true},
+		{"device/0/ack", 0, false},
+		{"device/abc/ack", 0, false},
+		{"device/-3/ack", 0, false},
+		{"device/control", 0, false},
+		{"device/12/ack/extra", 0, false},
+		{"sensor/12/ack", 0, false},
+	}
+	for _, tt := range tests {
+		id, ok := ParseDeviceTopicID(tt.topic)
+		if ok != tt.wantOK || id != tt.wantID {
+			t.Errorf("ParseDeviceTopicID(%q) = (%d, %t), want (%d, %t)", tt.topic, id, ok, tt.wantID, tt.wantOK)
+		}
+	}
+}
@@ -48,6 +48,33 @@ func SendPushNotificationToAll(title, body string, data map[string]string) error
 	return nil
 }
 
+// SendDevicePushNotificationToUser sends a device notification to a single user, if they registered a token
+func SendDevicePushNotificationToUser(userID, deviceID uint, body string, data map[string]string) {
+	go func() {
+		var user models.User
+		if err := database.DB.First(&user, userID).Error; err != nil {
+			log.Printf("Failed to fetch user %d: %v", userID, err)
+			return
+		}
+		if user.ExpoPushToken == "" {
+			return
+		}
+
+		var device models.Device
+		if err := database.DB.First(&device, deviceID).Error; err != nil {
+			log.Printf("Failed to fetch device %d: %v", deviceID, err)
+			return
+		}
+		title := device.Name
+		if title == "" {
+			title = fmt.Sprintf("Device %d", deviceID)
+		}
+		if err := SendPushNotification(user.ExpoPushToken, title, body, data); err != nil {
+			log.Printf("Failed to send notification to user %d: %v", userID, err)
+		}
+	}()
+}
+
 // New helper to send notification for a device by ID
 func SendDevicePushNotificationToAll(deviceID uint, body string, data map[string]string) {
 	go func() {
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+var ErrNoOpenFault = errors.New("device has no open fault")
+
+// switchOffVerified publishes the OFF command and waits for the device to confirm it with an
+// ACK or with status telemetry reporting the relay open. The command is republished up to
+// config.MaxRetries times with the wait doubling each time. During a graceful shutdown the
+// retries are cut short so they end before the shutdown deadline. If confirmation never arrives
+// the device is marked FAULT and admins are alerted. Returns true if the device confirmed.
+func (ds *DeviceService) switchOffVerified(device *models.Device, sessionID uint) bool {
+	deviceID := device.ID
+	pending := ds.expectAck(device)
+	defer ds.clearAck(deviceID, pending)
+	relayOff := ds.watchRelayOff(deviceID)
+	defer ds.unwatchRelayOff(deviceID, relayOff)
+
+	timeout := firstAckTimeout(device)
+	maxAttempts := Settings().MaxRetries + 1
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	attempts := 0
+	for n := 1; n <= maxAttempts; n++ {
+		wait, ok := offAttemptWait(n, timeout, ds.offVerificationDeadline(), time.Now())
+		if !ok {
+			log.Printf("[Shutdown] Out of time to confirm OFF for device %d", deviceID)
+			break
+		}
+		attempts = n
+
+		// Publish OFF command to the device's control topic
+		commandID, err := ds.publishCommand(device, "off")
+		if err != nil {
+			log.Printf("[MQTT] Failed to publish OFF command to device %d (attempt %d): %v", deviceID, n, err)
+		} else {
+			ds.awaitCommand(pending, commandID)
+		}
+
+		select {
+		case <-pending.ch:
+			log.Printf("[ACK] Device %d confirmed OFF by ACK (attempt %d of %d)", deviceID, n, maxAttempts)
+			return true
+		case <-relayOff:
+			log.Printf("[State] Device %d confirmed OFF by status telemetry (attempt %d of %d)", deviceID, n, maxAttempts)
+			return true
+		case <-time.After(wait):
+			log.Printf("[ACK] No OFF confirmation from device %d after %v (attempt %d of %d)", deviceID, wait, n, maxAttempts)
+		}
+
+		timeout = nextAckTimeout(timeout)
+	}
+
+	ds.raiseFault(device, sessionID, fmt.Sprintf("no OFF confirmation after %d attempts", attempts))
+	return false
+}
+
+// offAttemptWait returns how long OFF attempt n waits for confirmation: its backoff timeout,
+// cut short to end by giveUp (the zero time for no limit). ok is false once there is no time
+// left for another attempt; the first OFF command always goes out and waits at least a second.
+func offAttemptWait(n int, timeout time.Duration, giveUp, now time.Time) (wait time.Duration, ok bool) {
+	if giveUp.IsZero() {
+		return timeout, true
+	}
+	wait = min(timeout, giveUp.Sub(now))
+	if n > 1 && wait <= 0 {
+		return 0, false
+	}
+	return max(wait, time.Second), true
+}
+
+// watchRelayOff returns a channel that is signalled when the device reports its relay open.
+func (ds *DeviceService) watchRelayOff(deviceID uint) chan struct{} {
+	ch := make(chan struct{}, 1)
+	ds.relayWatchersMu.Lock()
+	ds.relayWatchers[deviceID] = ch
+	ds.relayWatchersMu.Unlock()
+	return ch
+}
+
+// unwatchRelayOff stops watching the device's relay.
+func (ds *DeviceService) unwatchRelayOff(deviceID uint, ch chan struct{}) {
+	ds.relayWatchersMu.Lock()
+	if ds.relayWatchers[deviceID] == ch {
+		delete(ds.relayWatchers, deviceID)
+	}
+	ds.relayWatchersMu.Unlock()
+}
+
+// ObserveRelayState is a telemetry listener. A sample reporting the relay open confirms a
+// pending OFF command, and clears the fault of a device that had not confirmed OFF. Unclaimed
+// devices cannot sign their reports, so their faults are left for an admin to acknowledge.
+func (ds *DeviceService) ObserveRelayState(t *models.DeviceTelemetry) {
+	if t.RelayOn == nil || *t.RelayOn {
+		return
+	}
+	ds.relayWatchersMu.Lock()
+	ch, watching := ds.relayWatchers[t.DeviceID]
+	ds.relayWatchersMu.Unlock()
+	if watching {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	var device models.Device
+	if err := database.GetDB().Select("id", "state", "secret").First(&device, t.DeviceID).Error; err != nil || device.State != models.StateFault {
+		return
+	}
+	if device.Secret == "" {
+		log.Printf("[Fault] Ignoring unsigned relay-open report from device %d; an admin must acknowledge the fault", device.ID)
+		return
+	}
+	ds.resolveFault(device.ID, nil, models.CauseRelayOpen, "device reported its relay open")
+}
+
+// raiseFault marks the device FAULT, records the fault and alerts admins. The device keeps its
+// interlock group until resolveFault runs.
+func (ds *DeviceService) raiseFault(device *models.Device, sessionID uint, detail string) {
+	db := database.GetDB()
+	log.Printf("[Fault] Device %d: %s", device.ID, detail)
+
+	if err := setDeviceState(device, models.StateFault, stateChange{cause: models.CauseOffUnconfirmed, sessionID: sessionID, detail: detail}); err != nil {
+		log.Printf("[DB] Failed to mark device %d FAULT: %v", device.ID, err)
+	}
+	fault := models.DeviceFault{
+		DeviceID:    device.ID,
+		Detail:      detail,
+		LastAlertAt: time.Now(),
+		AlertCount:  1,
+	}
+	if sessionID != 0 {
+		fault.SessionID = &sessionID
+	}
+	if err := db.Create(&fault).Error; err != nil {
+		log.Printf("[DB] Failed to record fault for device %d: %v", device.ID, err)
+	}
+
+	BroadcastEvent(map[string]interface{}{
+		"type":      "device_fault",
+		"device_id": device.ID,
+		"fault_id":  fault.ID,
+		"detail":    detail,
+	})
+	SendDevicePushNotificationToAdmin(
+		device.ID,
+		fmt.Sprintf("FAULT: device %d did not confirm it turned OFF (%s). It may still be running!", device.ID, detail),
+		map[string]string{
+			"device_id": fmt.Sprintf("%d", device.ID),
+			"fault_id":  fmt.Sprintf("%d", fault.ID),
+			"action":    "fault",
+		},
+	)
+}
+
+// resolveFault closes the device's open faults and returns it to rest.
+// adminID is the admin who cleared it by hand, or nil when the device confirmed OFF itself.
+func (ds *DeviceService) resolveFault(deviceID uint, adminID *uint, cause, resolution string) {
+	db := database.GetDB()
+	now := time.Now()
+	updates := map[string]interface{}{
+		"resolved_at": now,
+		"resolution":  resolution,
+	}
+	if adminID != nil {
+		updates["acknowledged_by"] = *adminID
+		updates["acknowledged_at"] = now
+	}
+	if err := db.Model(&models.DeviceFault{}).
+		Where("device_id = ? AND resolved_at IS NULL", deviceID).
+		Updates(updates).Error; err != nil {
+		log.Printf("[DB] Failed to resolve faults of device %d: %v", deviceID, err)
+	}
+	change := stateChange{cause: cause, detail: resolution}
+	if adminID != nil {
+		change.actorID = *adminID
+	}
+	var device models.Device
+	if err := db.First(&device, deviceID).Error; err == nil {
+		logTransitionError(deviceID, setDeviceState(&device, restingState(&device), change, models.StateFault))
+		// The device is confirmed OFF, so the interlock group it kept while in FAULT is free again
+		ds.releaseInterlock(&device)
+	}
+	log.Printf("[Fault] Device %d fault resolved: %s", deviceID, resolution)
+
+	BroadcastEvent(map[string]interface{}{
+		"type":       "device_fault_resolved",
+		"device_id":  deviceID,
+		"resolution": resolution,
+	})
+	SendDevicePushNotificationToAdmin(
+		deviceID,
+		fmt.Sprintf("Device %d is confirmed OFF again (%s)", deviceID, resolution),
+		map[string]string{"device_id": fmt.Sprintf("%d", deviceID), "action": "fault_resolved"},
+	)
+}
+
+// AcknowledgeFault stops the alerts for the device's open fault. With clear set, the admin
+// also confirms the device is OFF (e.g. after checking it on site) and the fault is resolved.
+func (ds *DeviceService) AcknowledgeFault(deviceID, adminID uint, clear bool) (*models.DeviceFault, error) {
+	db := database.GetDB()
+	var fault models.DeviceFault
+	if err := db.Where("device_id = ? AND resolved_at IS NULL", deviceID).Order("id DESC").First(&fault).Error; err != nil {
+		return nil, ErrNoOpenFault
+	}
+
+	if clear {
+		ds.resolveFault(deviceID, &adminID, models.CauseFaultCleared, "cleared by admin")
+		db.First(&fault, fault.ID)
+		return &fault, nil
+	}
+
+	now := time.Now()
+	if err := db.Model(&models.DeviceFault{}).
+		Where("device_id = ? AND resolved_at IS NULL AND acknowledged_at IS NULL", deviceID).
+		Updates(map[string]interface{}{
+			"acknowledged_by": adminID,
+			"acknowledged_at": now,
+		}).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("[Fault] Device %d fault acknowledged by admin %d", deviceID, adminID)
+	db.First(&fault, fault.ID)
+	return &fault, nil
+}
+
+// StartFaultEscalation re-alerts admins about unacknowledged faults every config.FaultAlertInterval,
+// until ctx is done.
+func StartFaultEscalation(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			escalateFaults(time.Now())
+		}
+	}()
+}
+
+// escalateFaults alerts admins again about every unacknowledged fault whose last alert is due for a repeat.
+func escalateFaults(now time.Time) {
+	interval := Settings().FaultAlertInterval
+	if interval <= 0 {
+		return
+	}
+	db := database.GetDB()
+	var faults []models.DeviceFault
+	if err := db.Where("acknowledged_at IS NULL AND resolved_at IS NULL AND last_alert_at <= ?", now.Add(-interval)).
+		Find(&faults).Error; err != nil {
+		log.Printf("[Fault] Failed to load open faults: %v", err)
+		return
+	}
+	for i := range faults {
+		fault := &faults[i]
+		fault.AlertCount++
+		if err := db.Model(fault).Updates(map[string]interface{}{
+			"last_alert_at": now,
+			"alert_count":   fault.AlertCount,
+		}).Error; err != nil {
+			log.Printf("[DB] Failed to update fault %d: %v", fault.ID, err)
+		}
+		log.Printf("[Fault] Re-alerting admins about device %d (alert %d)", fault.DeviceID, fault.AlertCount)
+		SendDevicePushNotificationToAdmin(
+			fault.DeviceID,
+			fmt.Sprintf("STILL FAULTED (alert %d): device %d has not confirmed OFF since %s. Please check it and acknowledge.",
+				fault.AlertCount, fault.DeviceID, fault.CreatedAt.Format("03:04 PM")),
+			map[string]string{
+				"device_id": fmt.Sprintf("%d", fault.DeviceID),
+				"fault_id":  fmt.Sprintf("%d", fault.ID),
+				"action":    "fault",
+			},
+		)
+	}
+}
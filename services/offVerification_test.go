@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOffAttemptWait(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		attempt  int
+		timeout  time.Duration
+		giveUp   time.Time
+		wantWait time.Duration
+		wantOK   bool
+	}{
+		{"no deadline", 3, 40 * time.Second, time.Time{}, 40 * time.Second, true},
+		{"deadline far off", 2, 20 * time.Second, now.Add(time.Minute), 20 * time.Second, true},
+		{"cut short by the deadline", 2, 40 * time.Second, now.Add(15 * time.Second), 15 * time.Second, true},
+		{"retry out of time", 2, 20 * time.Second, now, 0, false},
+		{"retry past the deadline", 3, 40 * time.Second, now.Add(-time.Second), 0, false},
+		{"first attempt always goes out", 1, 10 * time.Second, now.Add(-5 * time.Second), time.Second, true},
+		{"first attempt waits at least a second", 1, 10 * time.Second, now.Add(200 * time.Millisecond), time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, ok := offAttemptWait(tt.attempt, tt.timeout, tt.giveUp, now)
+			if wait != tt.wantWait || ok != tt.wantOK {
+				t.Errorf("offAttemptWait = (%v, %t), want (%v, %t)", wait, ok, tt.wantWait, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+const (
+	presenceCheckInterval = 15 * time.Second // How often silent devices are looked for
+	presenceWriteInterval = 10 * time.Second // Minimum gap between last_seen_at writes for a busy device
+)
+
+// presenceTracker remembers when each device was last heard from, so frequent
+// status messages do not turn into a database write each.
+type presenceTracker struct {
+	mu       sync.Mutex
+	written  map[uint]time.Time // last_seen_at value last written to the database
+	startMon sync.Once
+}
+
+var presence = &presenceTracker{
+	written: make(map[uint]time.Time),
+}
+
+// TouchDevice records that a device was heard from (status, ACK or heartbeat) and
+// brings it back online if it had been marked offline.
+func TouchDevice(deviceID uint) {
+	now := time.Now()
+
+	presence.mu.Lock()
+	last, known := presence.written[deviceID]
+	if known && now.Sub(last) < presenceWriteInterval {
+		presence.mu.Unlock()
+		return
+	}
+	presence.written[deviceID] = now
+	presence.mu.Unlock()
+
+	db := database.GetDB()
+	result := db.Model(&models.Device{}).
+		Where("id = ? AND online = ?", deviceID, false).
+		Updates(map[string]interface{}{"last_seen_at": now, "online": true})
+	if result.Error != nil {
+		log.Printf("[Presence] Failed to update device %d: %v", deviceID, result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("[Presence] Device %d is online", deviceID)
+		device := models.Device{}
+		device.ID = deviceID
+		setDeviceState(&device, models.StateIdle, stateChange{cause: models.CauseCameOnline}, models.StateOffline)
+		announcePresence(deviceID, true, now)
+		return
+	}
+	if err := db.Model(&models.Device{}).Where("id = ?", deviceID).Update("last_seen_at", now).Error; err != nil {
+		log.Printf("[Presence] Failed to update device %d: %v", deviceID, err)
+	}
+}
+
+// StartPresenceMonitor launches the loop that marks silent devices offline (only once), until ctx is done.
+func StartPresenceMonitor(ctx context.Context) {
+	presence.startMon.Do(func() {
+		go func() {
+			ticker := time.NewTicker(presenceCheckInterval)
+			defer ticker.Stop()
+			for {
+				markSilentDevicesOffline()
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	})
+}
+
+// markSilentDevicesOffline marks online devices that have been silent for longer than config.OfflineAfter as offline.
+func markSilentDevicesOffline() {
+	offlineAfter := Settings().OfflineAfter
+	if offlineAfter <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-offlineAfter)
+
+	db := database.GetDB()
+	var silent []models.Device
+	if err := db.Where("online = ? AND (last_seen_at IS NULL OR last_seen_at < ?)", true, cutoff).Find(&silent).Error; err != nil {
+		log.Printf("[Presence] Failed to look for silent devices: %v", err)
+		return
+	}
+	for _, device := range silent {
+		result := db.Model(&models.Device{}).
+			Where("id = ? AND online = ? AND (last_seen_at IS NULL OR last_seen_at < ?)", device.ID, true, cutoff).
+			Update("online", false)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		log.Printf("[Presence] Device %d is offline (last seen %v)", device.ID, device.LastSeenAt)
+		// Only an idle device goes OFFLINE; a busy one keeps its state until its session ends
+		setDeviceState(&device, models.StateOffline, stateChange{cause: models.CauseWentOffline}, models.StateIdle)
+		var lastSeen time.Time
+		if device.LastSeenAt != nil {
+			lastSeen = *device.LastSeenAt
+		}
+		announcePresence(device.ID, false, lastSeen)
+	}
+}
+
+// announcePresence tells WebSocket clients and admins that a device went online or offline.
+func announcePresence(deviceID uint, online bool, lastSeen time.Time) {
+	BroadcastEvent(map[string]interface{}{
+		"type":         "device_presence",
+		"device_id":    deviceID,
+		"online":       online,
+		"last_seen_at": lastSeen,
+	})
+	status := "offline"
+	if online {
+		status = "back online"
+	}
+	SendDevicePushNotificationToAdmin(
+		deviceID,
+		fmt.Sprintf("Device %d is %s", deviceID, status),
+		map[string]string{
+			"device_id": fmt.Sprintf("%d", deviceID),
+			"action":    "presence",
+			"online":    fmt.Sprintf("%t", online),
+		},
+	)
+}
+
+// DeviceIsOnline reports whether activations may be sent to the device.
+// Always true when offline detection is disabled.
+func DeviceIsOnline(device *models.Device) bool {
+	return Settings().OfflineAfter <= 0 || device.Online
+}
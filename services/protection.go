@@ -0,0 +1,61 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+// protectionViolation returns a description of the first threshold the sample breaks, or "" if none.
+func protectionViolation(device *models.Device, t *models.DeviceTelemetry, startedAt time.Time) string {
+	if device.MaxCurrent != nil && t.Current != nil && *t.Current > *device.MaxCurrent {
+		return fmt.Sprintf("overload: current %.2f A above maximum %.2f A", *t.Current, *device.MaxCurrent)
+	}
+	if device.MinVoltage != nil && t.Voltage != nil && *t.Voltage < *device.MinVoltage {
+		return fmt.Sprintf("undervoltage: voltage %.1f V below minimum %.1f V", *t.Voltage, *device.MinVoltage)
+	}
+	if device.MinFlowRate != nil && t.FlowRate != nil && *t.FlowRate < *device.MinFlowRate &&
+		t.RecordedAt.Sub(startedAt) >= device.FlowGracePeriod {
+		return fmt.Sprintf("dry run: flow %.2f L/min below minimum %.2f L/min", *t.FlowRate, *device.MinFlowRate)
+	}
+	return ""
+}
+
+// CheckProtection compares a telemetry sample with the protection thresholds of the device
+// while it runs, and shuts the device down with a protection_trip reason on a violation.
+func (ds *DeviceService) CheckProtection(t *models.DeviceTelemetry) {
+	ds.activeActivationsMu.Lock()
+	active, exists := ds.activeActivations[t.DeviceID]
+	if !exists || active.sessionID == 0 || active.stopping || t.RecordedAt.Before(active.startedAt) {
+		ds.activeActivationsMu.Unlock()
+		return
+	}
+	violation := protectionViolation(&active.device, t, active.startedAt)
+	if violation == "" {
+		ds.activeActivationsMu.Unlock()
+		return
+	}
+	active.stopping = true
+	sessionID := active.sessionID
+	ds.activeActivationsMu.Unlock()
+
+	log.Printf("[Protection] Tripping device %d (session %d): %s", t.DeviceID, sessionID, violation)
+	active.cancel(&stopCause{reason: models.ReasonProtection, detail: violation})
+
+	BroadcastEvent(map[string]interface{}{
+		"type":       "protection_trip",
+		"device_id":  t.DeviceID,
+		"session_id": sessionID,
+		"detail":     violation,
+	})
+	SendDevicePushNotificationToAdmin(
+		t.DeviceID,
+		fmt.Sprintf("Device %d was shut down by protection: %s", t.DeviceID, violation),
+		map[string]string{
+			"device_id": fmt.Sprintf("%d", t.DeviceID),
+			"action":    "protection_trip",
+		},
+	)
+}
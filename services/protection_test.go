@@ -0,0 +1,54 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+func TestProtectionViolation(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	device := &models.Device{
+		MaxCurrent:      f(10),
+		MinVoltage:      f(180),
+		MinFlowRate:     f(2),
+		FlowGracePeriod: time.Minute,
+	}
+	started := time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		sample models.DeviceTelemetry
+		after  time.Duration // Sample time since start
+		want   string        // Prefix of the violation, "" for none
+	}{
+		{"healthy", models.DeviceTelemetry{Current: f(6), Voltage: f(230), FlowRate: f(12)}, 2 * time.Minute, ""},
+		{"no readings", models.DeviceTelemetry{}, 2 * time.Minute, ""},
+		{"overload", models.DeviceTelemetry{Current: f(10.5)}, 2 * time.Minute, "overload"},
+		{"current at the limit", models.DeviceTelemetry{Current: f(10)}, 2 * time.Minute, ""},
+		{"undervoltage", models.DeviceTelemetry{Voltage: f(170)}, 2 * time.Minute, "undervoltage"},
+		{"dry run", models.DeviceTelemetry{FlowRate: f(0.5)}, 2 * time.Minute, "dry run"},
+		{"low flow during grace period", models.DeviceTelemetry{FlowRate: f(0.5)}, 30 * time.Second, ""},
+		{"low flow as grace period ends", models.DeviceTelemetry{FlowRate: f(0.5)}, time.Minute, "dry run"},
+		{"overload reported before dry run", models.DeviceTelemetry{Current: f(12), FlowRate: f(0)}, 2 * time.Minute, "overload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sample := tt.sample
+			sample.RecordedAt = started.Add(tt.after)
+			got := protectionViolation(device, &sample, started)
+			if (tt.want == "") != (got == "") || !strings.HasPrefix(got, tt.want) {
+				t.Errorf("protectionViolation = %q, want %q...", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no thresholds", func(t *testing.T) {
+		sample := models.DeviceTelemetry{Current: f(400), Voltage: f(0), FlowRate: f(0), RecordedAt: started.Add(time.Hour)}
+		if got := protectionViolation(&models.Device{}, &sample, started); got != "" {
+			t.Errorf("protectionViolation = %q, want none", got)
+		}
+	})
+}
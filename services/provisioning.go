@@ -0,0 +1,339 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqttlib "github.com/eclipse/paho.mqtt.golang"
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidClaimCode = errors.New("invalid or expired claim code")
+var ErrUnknownDevice = errors.New("unknown device")
+var ErrBadSignature = errors.New("message signature does not match the device")
+var ErrStaleMessage = errors.New("message timestamp is outside the allowed window")
+var ErrReplayedMessage = errors.New("message has already been received")
+var ErrUnsignedMessage = errors.New("device has not been claimed and unsigned messages are not accepted")
+
+// ProvisionedDevice is what a device receives when it redeems its claim code.
+type ProvisionedDevice struct {
+	DeviceID        uint   `json:"device_id"`
+	Secret          string `json:"secret,omitempty"`           // Returned over HTTP only
+	EncryptedSecret string `json:"encrypted_secret,omitempty"` // Returned over MQTT, see encryptClaimSecret
+	ControlTopic    string `json:"control_topic"`
+	StatusTopic     string `json:"status_topic"`
+	AckTopic        string `json:"ack_topic"`
+	HeartbeatTopic  string `json:"heartbeat_topic"`
+}
+
+// signedMessage is the envelope claimed devices wrap their status, ACK and heartbeat payloads in.
+// Sig is the hex HMAC-SHA256, keyed with the device secret, of "<topic>|<ts>|<body>".
+type signedMessage struct {
+	TS   int64  `json:"ts"`   // Unix seconds
+	Body string `json:"body"` // The original payload
+	Sig  string `json:"sig"`
+}
+
+// hashClaimCode returns the stored form of a claim code. Codes are compared case-insensitively.
+func hashClaimCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueClaimCode creates a new one-time claim code for a device, replacing any outstanding one.
+// Only the hash is stored, so the code must be handed to the installer now.
+func IssueClaimCode(deviceID uint) (string, time.Time, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	expiresAt := time.Now().Add(Settings().ClaimCodeTTL)
+
+	result := database.GetDB().Model(&models.Device{}).Where("id = ?", deviceID).Updates(map[string]interface{}{
+		"claim_code_hash":       hashClaimCode(code),
+		"claim_code_expires_at": expiresAt,
+	})
+	if result.Error != nil {
+		return "", time.Time{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", time.Time{}, ErrDeviceNotFound
+	}
+	log.Printf("[Provision] Claim code issued for device %d (expires %s)", deviceID, expiresAt.Format(time.RFC3339))
+	return code, expiresAt, nil
+}
+
+// ClaimDevice redeems a claim code. The code is used up and the device gets a new secret,
+// which replaces any secret a previous controller had.
+func ClaimDevice(code string) (*ProvisionedDevice, error) {
+	return claimDeviceByHash(hashClaimCode(code), nil)
+}
+
+// claimDeviceByHash redeems the claim code with the given stored hash. If deliver is set, it is
+// called with the new credentials before the claim is committed; when it fails the claim is rolled
+// back, so the code stays valid and the device keeps its previous secret.
+func claimDeviceByHash(hash string, deliver func(*ProvisionedDevice) error) (*ProvisionedDevice, error) {
+	db := database.GetDB()
+
+	var device models.Device
+	if err := db.Where("claim_code_hash = ? AND claim_code_expires_at > ?", hash, time.Now()).First(&device).Error; err != nil {
+		return nil, ErrInvalidClaimCode
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	secret := hex.EncodeToString(b)
+	now := time.Now()
+
+	id := strconv.FormatUint(uint64(device.ID), 10)
+	provisioned := &ProvisionedDevice{
+		DeviceID:       device.ID,
+		Secret:         secret,
+		ControlTopic:   DeviceControlTopic(&device),
+		StatusTopic:    strings.Replace(MQTTTopicDeviceStatus, "+", id, 1),
+		AckTopic:       strings.Replace(MQTTAckTopic, "+", id, 1),
+		HeartbeatTopic: strings.Replace(MQTTTopicDeviceHeartbeat, "+", id, 1),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Only one redemption of the same code can succeed
+		result := tx.Model(&device).Where("claim_code_hash = ?", hash).Updates(map[string]interface{}{
+			"claim_code_hash":       "",
+			"claim_code_expires_at": nil,
+			"secret":                secret,
+			"claimed_at":            now,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidClaimCode
+		}
+		if deliver != nil {
+			return deliver(provisioned)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[Provision] Device %d (%s) claimed", device.ID, device.Name)
+	return provisioned, nil
+}
+
+// SignDeviceMessage returns the signature a device with the given secret puts on a message.
+func SignDeviceMessage(secret, topic string, ts int64, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s|%d|%s", topic, ts, body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyDeviceMessage checks that a message on a device topic comes from the device that claimed
+// that ID, and returns the payload inside the signed envelope. Devices that have never been
+// claimed send plain payloads, which are returned unchanged unless config.RequireSigned is set.
+func VerifyDeviceMessage(deviceID uint, topic string, payload []byte) ([]byte, error) {
+	var device models.Device
+	if err := database.GetDB().Select("id", "secret").First(&device, deviceID).Error; err != nil {
+		return nil, ErrUnknownDevice
+	}
+	if device.Secret == "" {
+		if Settings().RequireSigned {
+			return nil, ErrUnsignedMessage
+		}
+		return payload, nil
+	}
+	return receivedMessages.verify(&device, topic, payload, time.Now(), Settings().MessageMaxSkew)
+}
+
+// verify checks the signature and timestamp of a signed message from a claimed device and
+// returns the payload inside the envelope. Each message is accepted only once.
+func (l *messageLog) verify(device *models.Device, topic string, payload []byte, now time.Time, maxSkew time.Duration) ([]byte, error) {
+	var msg signedMessage
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.Sig == "" {
+		return nil, ErrBadSignature
+	}
+	expected := SignDeviceMessage(device.Secret, topic, msg.TS, msg.Body)
+	if !hmac.Equal([]byte(strings.ToLower(msg.Sig)), []byte(expected)) {
+		return nil, ErrBadSignature
+	}
+	skew := now.Sub(time.Unix(msg.TS, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return nil, ErrStaleMessage
+	}
+	// A captured message stays valid for the whole skew window, so each one is accepted only once
+	if !l.firstSeen(device.ID, strings.ToLower(msg.Sig), time.Unix(msg.TS, 0).Add(maxSkew), now) {
+		return nil, ErrReplayedMessage
+	}
+	return []byte(msg.Body), nil
+}
+
+// messageLog remembers the signatures of recently verified messages until their timestamps
+// leave the allowed window, so replays can be rejected.
+type messageLog struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time // "<device ID>|<sig>" -> when the message stops being accepted anyway
+	pruned time.Time            // When expired entries were last dropped
+}
+
+var receivedMessages = &messageLog{seen: make(map[string]time.Time)}
+
+// firstSeen records a message and reports whether it had not been seen before. The entry is
+// kept until expiresAt, after which the timestamp check rejects the message by itself.
+func (l *messageLog) firstSeen(deviceID uint, sig string, expiresAt, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.pruned) >= time.Second {
+		for key, expiry := range l.seen {
+			if !expiry.After(now) {
+				delete(l.seen, key)
+			}
+		}
+		l.pruned = now
+	}
+	key := strconv.FormatUint(uint64(deviceID), 10) + "|" + sig
+	if _, seen := l.seen[key]; seen {
+		return false
+	}
+	l.seen[key] = expiresAt
+	return true
+}
+
+// claimCodeHashForID finds the outstanding claim code hash whose claim ID (hex SHA-256 of the
+// raw hash) matches. Devices send the claim ID over MQTT so the code itself never crosses the broker.
+func claimCodeHashForID(claimID string) (string, bool) {
+	var hashes []string
+	if err := database.GetDB().Model(&models.Device{}).
+		Where("claim_code_hash <> '' AND claim_code_expires_at > ?", time.Now()).
+		Pluck("claim_code_hash", &hashes).Error; err != nil {
+		return "", false
+	}
+	for _, hash := range hashes {
+		raw, err := hex.DecodeString(hash)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(raw)
+		if hmac.Equal([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(claimID))) {
+			return hash, true
+		}
+	}
+	return "", false
+}
+
+// encryptClaimSecret encrypts a new device secret for the MQTT provisioning response.
+// The key is the raw claim code hash (SHA-256 of the code), which only the installer, the device
+// and the database know; the result is base64 of the 12-byte AES-256-GCM nonce followed by the ciphertext.
+func encryptClaimSecret(hash, secret string) (string, error) {
+	key, err := hex.DecodeString(hash)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// SubscribeToProvisioning lets devices redeem claim codes over MQTT. Every controller shares the
+// broker identity, so neither the code nor the secret is sent in the clear: a device publishes
+// {"claim_id": "<hex SHA-256(SHA-256(code))>", "nonce": "...", "proof": "<see claimProof>"} and reads
+// provision/<nonce>/response, whose encrypted_secret only a holder of the code can decrypt (see
+// encryptClaimSecret). The claim ID is visible to every controller, so the proof is what shows the
+// sender holds the code; requests without a valid proof leave the code unspent.
+func SubscribeToProvisioning() {
+	Subscribe(MQTTTopicProvisionRequest, func(client mqttlib.Client, msg mqttlib.Message) {
+		var request struct {
+			ClaimID string `json:"claim_id"`
+			Nonce   string `json:"nonce"`
+			Proof   string `json:"proof"`
+		}
+		if err := json.Unmarshal(msg.Payload(), &request); err != nil || request.Nonce == "" ||
+			strings.ContainsAny(request.Nonce, "/+#") {
+			log.Printf("[Provision] Ignoring malformed provisioning request")
+			return
+		}
+
+		// Handlers run in order on the client's router, so don't wait for the claim and publish here
+		go func() {
+			respond := func(body []byte) error {
+				return Publish(fmt.Sprintf(MQTTTopicProvisionResponse, request.Nonce), body, 1, false)
+			}
+			if err := claimOverMQTT(request.ClaimID, request.Nonce, request.Proof, respond); err != nil {
+				log.Printf("[Provision] Claim over MQTT failed: %v", err)
+				body, _ := json.Marshal(map[string]string{"error": err.Error()})
+				if err := respond(body); err != nil {
+					log.Printf("[Provision] Failed to publish provisioning response: %v", err)
+				}
+			}
+		}()
+	})
+}
+
+// claimProof returns the proof a device sends with a provisioning request: the hex HMAC-SHA256
+// of the request nonce, keyed with the raw claim code hash.
+func claimProof(hash, nonce string) (string, error) {
+	key, err := hex.DecodeString(hash)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// claimOverMQTT redeems the claim code behind a claim ID and sends the device its encrypted secret.
+// The claim only takes effect once the response is published, so a device that never receives
+// its secret can retry with the same code.
+func claimOverMQTT(claimID, nonce, proof string, respond func(body []byte) error) error {
+	hash, ok := claimCodeHashForID(claimID)
+	if !ok {
+		return ErrInvalidClaimCode
+	}
+	expected, err := claimProof(hash, nonce)
+	if err != nil || !hmac.Equal([]byte(strings.ToLower(proof)), []byte(expected)) {
+		return ErrInvalidClaimCode
+	}
+	_, err = claimDeviceByHash(hash, func(provisioned *ProvisionedDevice) error {
+		response := *provisioned
+		encrypted, err := encryptClaimSecret(hash, response.Secret)
+		if err != nil {
+			return err
+		}
+		response.Secret = ""
+		response.EncryptedSecret = encrypted
+		body, err := json.Marshal(response)
+		if err != nil {
+			return err
+		}
+		return respond(body)
+	})
+	return err
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+func TestSignDeviceMessage(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("device/4/ack|1754290678|9f2c01ab"))
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	if got := SignDeviceMessage("s3cret", "device/4/ack", 1754290678, "9f2c01ab"); got != want {
+		t.Errorf("SignDeviceMessage = %s, want %s", got, want)
+	}
+	// The topic is signed, so a message cannot be moved to another device or topic
+	if SignDeviceMessage("s3cret", "device/5/ack", 1754290678, "9f2c01ab") == want {
+		t.Error("signature does not depend on the topic")
+	}
+}
+
+func TestVerifySignedMessage(t *testing.T) {
+	device := &models.Device{Secret: "s3cret"}
+	device.ID = 4
+	const topic = "device/4/status"
+	now := time.Unix(1754290678, 0)
+	maxSkew := 5 * time.Minute
+
+	envelope := func(secret string, ts int64, body string) []byte {
+		payload, _ := json.Marshal(signedMessage{TS: ts, Body: body, Sig: SignDeviceMessage(secret, topic, ts, body)})
+		return payload
+	}
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    error
+	}{
+		{"valid", envelope("s3cret", now.Unix(), `{"v": 1}`), nil},
+		{"slightly old", envelope("s3cret", now.Add(-4*time.Minute).Unix(), `{"v": 1, "rssi": -60}`), nil},
+		{"wrong secret", envelope("other", now.Unix(), `{"v": 1}`), ErrBadSignature},
+		{"unsigned payload", []byte(`{"v": 1}`), ErrBadSignature},
+		{"not JSON", []byte("ON"), ErrBadSignature},
+		{"too old", envelope("s3cret", now.Add(-6*time.Minute).Unix(), `{"v": 1}`), ErrStaleMessage},
+		{"too far ahead", envelope("s3cret", now.Add(6*time.Minute).Unix(), `{"v": 1}`), ErrStaleMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &messageLog{seen: make(map[string]time.Time)}
+			body, err := log.verify(device, topic, tt.payload, now, maxSkew)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("verify error = %v, want %v", err, tt.want)
+			}
+			if err == nil && !strings.HasPrefix(string(body), `{"v": 1`) {
+				t.Errorf("verify returned body %q", body)
+			}
+		})
+	}
+
+	t.Run("tampered body", func(t *testing.T) {
+		var msg signedMessage
+		json.Unmarshal(envelope("s3cret", now.Unix(), `{"v": 1, "relay": "on"}`), &msg)
+		msg.Body = `{"v": 1, "relay": "off"}`
+		payload, _ := json.Marshal(msg)
+		log := &messageLog{seen: make(map[string]time.Time)}
+		if _, err := log.verify(device, topic, payload, now, maxSkew); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("verify error = %v, want ErrBadSignature", err)
+		}
+	})
+
+	t.Run("replayed", func(t *testing.T) {
+		log := &messageLog{seen: make(map[string]time.Time)}
+		payload := envelope("s3cret", now.Unix(), "9f2c01ab")
+		if _, err := log.verify(device, topic, payload, now, maxSkew); err != nil {
+			t.Fatalf("first delivery: %v", err)
+		}
+		if _, err := log.verify(device, topic, payload, now.Add(time.Minute), maxSkew); !errors.Is(err, ErrReplayedMessage) {
+			t.Errorf("replay error = %v, want ErrReplayedMessage", err)
+		}
+		// Another device may legitimately send the same signature bytes; only repeats per device count
+		other := &models.Device{Secret: "s3cret"}
+		other.ID = 5
+		if _, err := log.verify(other, topic, payload, now, maxSkew); err != nil {
+			t.Errorf("same message from another device: %v", err)
+		}
+	})
+}
+
+func TestMessageLogForgetsExpiredMessages(t *testing.T) {
+	log := &messageLog{seen: make(map[string]time.Time)}
+	now := time.Unix(1754290678, 0)
+	if !log.firstSeen(4, "ab", now.Add(time.Minute), now) {
+		t.Fatal("first message reported as seen")
+	}
+	if log.firstSeen(4, "ab", now.Add(time.Minute), now.Add(30*time.Second)) {
+		t.Error("repeat inside the window accepted")
+	}
+	if !log.firstSeen(4, "ab", now.Add(3*time.Minute), now.Add(2*time.Minute)) {
+		t.Error("entry kept after it expired")
+	}
+	if len(log.seen) != 1 {
+		t.Errorf("log holds %d entries, want 1", len(log.seen))
+	}
+}
+
+func TestEncryptClaimSecret(t *testing.T) {
+	hash := hashClaimCode("abcd efgh")
+	encrypted, err := encryptClaimSecret(hash, "device-secret")
+	if err != nil {
+		t.Fatalf("encryptClaimSecret: %v", err)
+	}
+
+	// Decrypt the way a device does: key = raw claim code hash, nonce first
+	raw, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		t.Fatalf("not base64: %v", err)
+	}
+	key, _ := hex.DecodeString(hash)
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	if len(raw) < gcm.NonceSize() {
+		t.Fatalf("ciphertext too short: %d bytes", len(raw))
+	}
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+	if err != nil || string(plain) != "device-secret" {
+		t.Fatalf("decrypted %q, %v; want device-secret", plain, err)
+	}
+
+	again, _ := encryptClaimSecret(hash, "device-secret")
+	if again == encrypted {
+		t.Error("two encryptions of the same secret are identical; the nonce is not random")
+	}
+	if _, err := encryptClaimSecret("not hex", "device-secret"); err == nil {
+		t.Error("encryptClaimSecret accepted a malformed hash")
+	}
+}
+
+func TestClaimProof(t *testing.T) {
+	// Codes are compared case-insensitively, so a code typed in lower case hashes the same
+	hash := hashClaimCode("abcd efgh")
+	if hash != hashClaimCode(" ABCD EFGH ") {
+		t.Fatal("hashClaimCode depends on case or surrounding space")
+	}
+
+	key, _ := hex.DecodeString(hash)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("nonce-1"))
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	got, err := claimProof(hash, "nonce-1")
+	if err != nil || got != want {
+		t.Errorf("claimProof = %s, %v; want %s", got, err, want)
+	}
+	if other, _ := claimProof(hash, "nonce-2"); other == want {
+		t.Error("proof does not depend on the nonce")
+	}
+	if _, err := claimProof("not hex", "nonce-1"); err == nil {
+		t.Error("claimProof accepted a malformed hash")
+	}
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+	"gorm.io/gorm/clause"
+)
+
+var ErrUserQuotaExceeded = errors.New("daily quota exceeded for user")
+var ErrDeviceQuotaExceeded = errors.New("daily quota exceeded for device")
+
+// QuotaStatus summarises today's usage for a user and a device.
+type QuotaStatus struct {
+	Day         models.Date   // Local day the figures apply to
+	UserUsed    time.Duration // Run time charged to the user today (including open sessions)
+	UserQuota   time.Duration
+	DeviceUsed  time.Duration // Run time of the device today (including open sessions)
+	DeviceQuota time.Duration // 0 means no per-device limit
+}
+
+// quotaDay returns the local calendar day of t in the site timezone.
+func quotaDay(t time.Time, loc *time.Location) models.Date {
+	return models.DateOf(t, loc)
+}
+
+// localMidnight returns the start of the local day containing t.
+func localMidnight(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// QuotaStatusFor reads today's ledger for the user and device. Open sessions are counted
+// with the part of their planned run that falls on today, so that a running activation
+// (including one started before midnight) reserves its share of the quota.
+func QuotaStatusFor(userID, deviceID uint) (QuotaStatus, error) {
+	cfg := Settings()
+	loc := cfg.Location()
+	now := time.Now()
+	day := quotaDay(now, loc)
+	status := QuotaStatus{Day: day, UserQuota: cfg.DailyQuota, DeviceQuota: cfg.DeviceQuota}
+
+	db := database.GetDB()
+	if err := db.Model(&models.QuotaUsage{}).
+		Where("user_id = ? AND day = ?", userID, day).
+		Select("COALESCE(SUM(used), 0)::bigint").Scan(&status.UserUsed).Error; err != nil {
+		return status, err
+	}
+	if err := db.Model(&models.QuotaUsage{}).
+		Where("device_id = ? AND day = ?", deviceID, day).
+		Select("COALESCE(SUM(used), 0)::bigint").Scan(&status.DeviceUsed).Error; err != nil {
+		return status, err
+	}
+
+	midnight := localMidnight(now, loc)
+	var open []models.DeviceSession
+	if err := db.Where("ended_at IS NULL AND active_until > ? AND (user_id = ? OR device_id = ?)",
+		midnight, userID, deviceID).Find(&open).Error; err != nil {
+		return status, err
+	}
+	for i := range open {
+		session := &open[i]
+		planned := plannedSince(session, midnight)
+		if session.UserID == userID {
+			status.UserUsed += planned
+		}
+		if session.DeviceID == deviceID {
+			status.DeviceUsed += planned
+		}
+	}
+	return status, nil
+}
+
+// plannedSince returns the planned run time of an open session from midnight on.
+func plannedSince(session *models.DeviceSession, midnight time.Time) time.Duration {
+	start := session.StartedAt
+	if start.IsZero() {
+		start = session.CreatedAt
+	}
+	if start.Before(midnight) {
+		start = midnight
+	}
+	return max(session.ActiveUntil.Sub(start), 0)
+}
+
+// CheckQuota returns an error if running the device for d would exceed today's user or device quota.
+func CheckQuota(userID, deviceID uint, d time.Duration) error {
+	status, err := QuotaStatusFor(userID, deviceID)
+	if err != nil {
+		return err
+	}
+	return status.allows(d)
+}
+
+// allows returns an error if another d of run time would exceed either quota.
+func (status QuotaStatus) allows(d time.Duration) error {
+	if status.UserUsed+d > status.UserQuota {
+		return ErrUserQuotaExceeded
+	}
+	if status.DeviceQuota > 0 && status.DeviceUsed+d > status.DeviceQuota {
+		return ErrDeviceQuotaExceeded
+	}
+	return nil
+}
+
+// RecordSessionUsage writes the ledger entries for a closed session, splitting its run time
+// at local midnight. It is idempotent, so it can be replayed for the same session.
+func RecordSessionUsage(session *models.DeviceSession, start, end time.Time) error {
+	db := database.GetDB()
+	for _, usage := range splitUsageByDay(start, end, Settings().Location()) {
+		entry := models.QuotaUsage{
+			SessionID: session.ID,
+			UserID:    session.UserID,
+			DeviceID:  session.DeviceID,
+			Day:       usage.Day,
+			Used:      usage.Used,
+		}
+		if err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "session_id"}, {Name: "day"}},
+			DoUpdates: clause.AssignmentColumns([]string{"used", "updated_at"}),
+		}).Create(&entry).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dayUsage is the run time that falls on one local day.
+type dayUsage struct {
+	Day  models.Date // As returned by quotaDay
+	Used time.Duration
+}
+
+// splitUsageByDay splits the run time between start and end at each local midnight.
+func splitUsageByDay(start, end time.Time, loc *time.Location) []dayUsage {
+	var usage []dayUsage
+	for start.Before(end) {
+		dayEnd := localMidnight(start, loc).AddDate(0, 0, 1)
+		if dayEnd.After(end) {
+			dayEnd = end
+		}
+		usage = append(usage, dayUsage{Day: quotaDay(start, loc), Used: dayEnd.Sub(start)})
+		start = dayEnd
+	}
+	return usage
+}
+
+// SyncQuotaLedger rebuilds ledger entries for closed sessions that have none,
+// e.g. sessions closed before the ledger existed or while the database was unreachable.
+func SyncQuotaLedger() {
+	db := database.GetDB()
+	var sessions []models.DeviceSession
+	if err := db.
+		Where("ended_at IS NOT NULL OR reason <> ''").
+		Where("NOT EXISTS (SELECT 1 FROM quota_usages WHERE quota_usages.session_id = device_sessions.id)").
+		Find(&sessions).Error; err != nil {
+		log.Printf("[Quota] Failed to load sessions for ledger sync: %v", err)
+		return
+	}
+	for i := range sessions {
+		session := &sessions[i]
+		start, end := session.StartedAt, session.ActiveUntil
+		if start.IsZero() {
+			start = session.CreatedAt
+		}
+		if session.EndedAt != nil {
+			end = *session.EndedAt
+		}
+		if err := RecordSessionUsage(session, start, end); err != nil {
+			log.Printf("[Quota] Failed to record usage for session %d: %v", session.ID, err)
+		}
+	}
+	if len(sessions) > 0 {
+		log.Printf("[Quota] Rebuilt ledger entries for %d sessions", len(sessions))
+	}
+}
+
+// checkQuota is CheckQuota plus the quota held by requests that lanes are still dispatching.
+func (ds *DeviceService) checkQuota(userID, deviceID uint, d time.Duration) error {
+	ds.quotaMu.Lock()
+	defer ds.quotaMu.Unlock()
+	return ds.checkQuotaLocked(userID, deviceID, d)
+}
+
+// checkQuotaLocked is checkQuota for callers holding quotaMu.
+func (ds *DeviceService) checkQuotaLocked(userID, deviceID uint, d time.Duration) error {
+	status, err := QuotaStatusFor(userID, deviceID)
+	if err != nil {
+		return err
+	}
+	for _, held := range ds.quotaReservations {
+		if held.UserID == userID {
+			status.UserUsed += held.Duration
+		}
+		if held.DeviceID == deviceID {
+			status.DeviceUsed += held.Duration
+		}
+	}
+	return status.allows(d)
+}
+
+// reserveQuota checks the quota for a request and holds its run time until releaseQuota.
+func (ds *DeviceService) reserveQuota(req *models.ActivationRequest) error {
+	ds.quotaMu.Lock()
+	defer ds.quotaMu.Unlock()
+	if err := ds.checkQuotaLocked(req.UserID, req.DeviceID, req.Duration); err != nil {
+		return err
+	}
+	ds.quotaReservations[req.ID] = &DeviceRequest{UserID: req.UserID, DeviceID: req.DeviceID, Duration: req.Duration}
+	return nil
+}
+
+// releaseQuota drops a reservation made by reserveQuota. It is safe to call more than once.
+func (ds *DeviceService) releaseQuota(requestID uint) {
+	ds.quotaMu.Lock()
+	delete(ds.quotaReservations, requestID)
+	ds.quotaMu.Unlock()
+}
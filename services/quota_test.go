@@ -0,0 +1,114 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+func TestQuotaDay(t *testing.T) {
+	karachi := time.FixedZone("PKT", 5*60*60)
+	tests := []struct {
+		name string
+		t    time.Time
+		want models.Date
+	}{
+		{"local morning", time.Date(2026, 10, 16, 9, 0, 0, 0, karachi), models.Date("2026-10-16")},
+		{"just before local midnight", time.Date(2026, 10, 16, 23, 59, 0, 0, karachi), models.Date("2026-10-16")},
+		{"local midnight", time.Date(2026, 10, 17, 0, 0, 0, 0, karachi), models.Date("2026-10-17")},
+		{"UTC evening is the next local day", time.Date(2026, 10, 16, 20, 0, 0, 0, time.UTC), models.Date("2026-10-17")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quotaDay(tt.t, karachi); got != tt.want {
+				t.Errorf("quotaDay(%v) = %q, want %q", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitUsageByDay(t *testing.T) {
+	karachi := time.FixedZone("PKT", 5*60*60)
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 10, day, hour, min, 0, 0, karachi)
+	}
+	day := func(d int) models.Date {
+		return models.Date(fmt.Sprintf("2026-10-%02d", d))
+	}
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       []dayUsage
+	}{
+		{"empty", at(16, 10, 0), at(16, 10, 0), nil},
+		{"end before start", at(16, 10, 0), at(16, 9, 0), nil},
+		{"within a day", at(16, 10, 0), at(16, 11, 30), []dayUsage{{day(16), 90 * time.Minute}}},
+		{"ends at midnight", at(16, 23, 0), at(17, 0, 0), []dayUsage{{day(16), time.Hour}}},
+		{"crosses midnight", at(16, 23, 30), at(17, 0, 45), []dayUsage{{day(16), 30 * time.Minute}, {day(17), 45 * time.Minute}}},
+		{"spans a whole day", at(16, 22, 0), at(18, 1, 0), []dayUsage{{day(16), 2 * time.Hour}, {day(17), 24 * time.Hour}, {day(18), time.Hour}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitUsageByDay(tt.start, tt.end, karachi)
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitUsageByDay = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Day != tt.want[i].Day || got[i].Used != tt.want[i].Used {
+					t.Errorf("entry %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaStatusAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		status QuotaStatus
+		d      time.Duration
+		want   error
+	}{
+		{"within both", QuotaStatus{UserUsed: 20 * time.Minute, UserQuota: time.Hour, DeviceUsed: time.Hour, DeviceQuota: 2 * time.Hour}, 30 * time.Minute, nil},
+		{"exactly the user quota", QuotaStatus{UserUsed: 30 * time.Minute, UserQuota: time.Hour}, 30 * time.Minute, nil},
+		{"over the user quota", QuotaStatus{UserUsed: 31 * time.Minute, UserQuota: time.Hour}, 30 * time.Minute, ErrUserQuotaExceeded},
+		{"over the device quota", QuotaStatus{UserQuota: time.Hour, DeviceUsed: 100 * time.Minute, DeviceQuota: 2 * time.Hour}, 30 * time.Minute, ErrDeviceQuotaExceeded},
+		{"no device quota", QuotaStatus{UserQuota: time.Hour, DeviceUsed: 100 * time.Hour}, 30 * time.Minute, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.status.allows(tt.d); got != tt.want {
+				t.Errorf("allows(%v) = %v, want %v", tt.d, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlannedSince(t *testing.T) {
+	karachi := time.FixedZone("PKT", 5*60*60)
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 10, day, hour, min, 0, 0, karachi)
+	}
+	midnight := at(17, 0, 0)
+
+	tests := []struct {
+		name                string
+		startedAt, activeTo time.Time
+		want                time.Duration
+	}{
+		{"started today", at(17, 9, 0), at(17, 10, 30), 90 * time.Minute},
+		{"running across midnight", at(16, 23, 0), at(17, 1, 0), time.Hour},
+		{"ended before midnight", at(16, 22, 0), at(16, 23, 0), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &models.DeviceSession{StartedAt: tt.startedAt, ActiveUntil: tt.activeTo}
+			if got := plannedSince(session, midnight); got != tt.want {
+				t.Errorf("plannedSince = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
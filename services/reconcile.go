@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+// Recovery policies for sessions left open by a crashed process
+const (
+	RecoveryShutdown = "shutdown" // Turn the device OFF and close the session
+	RecoveryResume   = "resume"   // Keep the device ON for the remaining time
+)
+
+// resumableSession is a session the reconciler handed to its device's lane to finish.
+type resumableSession struct {
+	req     *models.ActivationRequest
+	session models.DeviceSession
+	device  models.Device
+}
+
+// Reconcile repairs device state after a crash. Sessions without a final OFF log are
+// either resumed for their remaining time or shut down, per config.RecoveryPolicy
+// (sessions handed off by a graceful shutdown are always resumed), and devices
+// still busy without an open session are switched OFF.
+// It must run before the lanes start. Devices it switches OFF are done so in the background,
+// and their lanes wait for that to finish before taking requests.
+func (ds *DeviceService) Reconcile() {
+	db := database.GetDB()
+	policy := Settings().RecoveryPolicy
+	now := time.Now()
+
+	var sessions []models.DeviceSession
+	if err := db.
+		Where("NOT EXISTS (SELECT 1 FROM device_logs WHERE device_logs.session_id = device_sessions.id AND device_logs.state = ? AND device_logs.deleted_at IS NULL)", "OFF").
+		Where("ended_at IS NULL AND reason = ''").
+		Order("COALESCE(started_at, created_at) DESC, id DESC").
+		Find(&sessions).Error; err != nil {
+		log.Printf("[Recovery] Failed to load open sessions: %v", err)
+		return
+	}
+
+	handled := make(map[uint]bool)
+	for i := range sessions {
+		session := sessions[i]
+		// Sessions opened before start times were recorded count from when they were created
+		if session.StartedAt.IsZero() {
+			session.StartedAt = session.CreatedAt
+		}
+		var device models.Device
+		if err := db.First(&device, session.DeviceID).Error; err != nil {
+			log.Printf("[Recovery] Session %d references missing device %d", session.ID, session.DeviceID)
+			continue
+		}
+		if handled[device.ID] {
+			// Only one session per device can really be running, and the newest one decides what
+			// happens to the device; older leftovers are closed on paper only
+			log.Printf("[Recovery] Closing leftover session %d on device %d", session.ID, device.ID)
+			recordSessionEnd(&device, &session, session.StartedAt, now, &stopCause{reason: models.ReasonRecovered})
+			ds.finishRecoveredRequest(session.ID, models.ActivationCancelled)
+			continue
+		}
+		handled[device.ID] = true
+
+		req := ds.recoveredRequest(&session)
+		remaining := session.ActiveUntil.Sub(now)
+		// Sessions handed off by a graceful shutdown are always resumed
+		if (policy == RecoveryResume || session.HandedOffAt != nil) && remaining > 0 {
+			log.Printf("[Recovery] Resuming session %d on device %d for the remaining %v", session.ID, device.ID, remaining.Round(time.Second))
+			if session.HandedOffAt != nil {
+				if err := db.Model(&session).Update("handed_off_at", nil).Error; err != nil {
+					log.Printf("[Recovery] Failed to clear handoff of session %d: %v", session.ID, err)
+				}
+			}
+			ds.lanesMu.Lock()
+			ds.resumable[device.ID] = &resumableSession{req: req, session: session, device: device}
+			ds.lanesMu.Unlock()
+			continue
+		}
+
+		log.Printf("[Recovery] Shutting down session %d on device %d", session.ID, device.ID)
+		// Waiting for the device to confirm OFF can take a while, so don't hold up startup
+		ds.recoverInBackground(device.ID, func() {
+			ds.closeSession(&device, &session, session.StartedAt, now, &stopCause{reason: models.ReasonRecovered})
+			if remaining > 0 {
+				setStatus(req, models.ActivationCancelled, models.ReasonRecovered)
+			} else {
+				setStatus(req, models.ActivationCompleted, models.ReasonRecovered)
+			}
+			SendDevicePushNotificationToAdmin(
+				device.ID,
+				fmt.Sprintf("Device %d was left ON by a backend restart and has been switched OFF", device.ID),
+				map[string]string{"device_id": fmt.Sprintf("%d", device.ID), "action": "off"},
+			)
+		})
+	}
+
+	// Devices marked ON with no session to account for them
+	var stale []models.Device
+	if err := db.Where("state IN ?", busyStates).Find(&stale).Error; err != nil {
+		log.Printf("[Recovery] Failed to load busy devices: %v", err)
+		return
+	}
+	for i := range stale {
+		device := stale[i]
+		if handled[device.ID] {
+			continue
+		}
+		log.Printf("[Recovery] Device %d is %s without an open session. Switching it OFF.", device.ID, device.State)
+		ds.recoverInBackground(device.ID, func() {
+			logTransitionError(device.ID, setDeviceState(&device, models.StateStopping, stateChange{cause: models.CauseRecoveryCleanup}))
+			if !ds.switchOffVerified(&device, 0) {
+				return
+			}
+			if err := setDeviceState(&device, restingState(&device), stateChange{cause: models.CauseOffConfirmed}); err != nil {
+				log.Printf("[DB] Failed to turn OFF device %d: %v\n", device.ID, err)
+			}
+		})
+	}
+
+	// Running requests whose session was closed above (or never created) are finished
+	if err := db.Model(&models.ActivationRequest{}).
+		Where("status = ? AND (session_id IS NULL OR session_id NOT IN (SELECT id FROM device_sessions WHERE ended_at IS NULL))", models.ActivationRunning).
+		Updates(map[string]interface{}{
+			"status":        models.ActivationCancelled,
+			"status_reason": models.ReasonRecovered,
+			"finished_at":   now,
+		}).Error; err != nil {
+		log.Printf("[Recovery] Failed to close orphaned running requests: %v", err)
+	}
+}
+
+// recoverInBackground runs a recovery step for a device without holding up startup.
+// The device's lane waits for it in waitRecovery.
+func (ds *DeviceService) recoverInBackground(deviceID uint, step func()) {
+	done := make(chan struct{})
+	ds.lanesMu.Lock()
+	ds.recovering[deviceID] = done
+	ds.lanesMu.Unlock()
+	go func() {
+		defer func() {
+			ds.lanesMu.Lock()
+			delete(ds.recovering, deviceID)
+			ds.lanesMu.Unlock()
+			close(done)
+		}()
+		step()
+	}()
+}
+
+// waitRecovery blocks until the reconciler has finished with the device.
+// Returns false if the service shut down first.
+func (ds *DeviceService) waitRecovery(deviceID uint) bool {
+	ds.lanesMu.Lock()
+	done, recovering := ds.recovering[deviceID]
+	ds.lanesMu.Unlock()
+	if !recovering {
+		return true
+	}
+	log.Printf("[Recovery] Holding the queue of device %d until it is switched OFF", deviceID)
+	select {
+	case <-done:
+		return true
+	case <-ds.base.Done():
+		return false
+	}
+}
+
+// recoveredRequest returns the running request that owns a session, creating one for
+// sessions that predate the durable queue so the lane has something to report on.
+func (ds *DeviceService) recoveredRequest(session *models.DeviceSession) *models.ActivationRequest {
+	db := database.GetDB()
+	var req models.ActivationRequest
+	if err := db.Where("session_id = ?", session.ID).First(&req).Error; err == nil {
+		return &req
+	}
+	startedAt := session.StartedAt
+	if startedAt.IsZero() {
+		startedAt = session.CreatedAt
+	}
+	req = models.ActivationRequest{
+		UserID:       session.UserID,
+		DeviceID:     session.DeviceID,
+		Duration:     session.ActiveUntil.Sub(startedAt),
+		Origin:       session.Origin,
+		ScheduleID:   session.ScheduleID,
+		Status:       models.ActivationRunning,
+		StatusReason: models.ReasonRecovered,
+		SessionID:    &session.ID,
+		StartedAt:    &startedAt,
+	}
+	if req.Origin == "" {
+		req.Origin = models.OriginManual
+	}
+	if err := db.Create(&req).Error; err != nil {
+		log.Printf("[Recovery] Failed to create request for session %d: %v", session.ID, err)
+	}
+	return &req
+}
+
+// finishRecoveredRequest moves the request that owns a session to a final status.
+func (ds *DeviceService) finishRecoveredRequest(sessionID uint, status string) {
+	var req models.ActivationRequest
+	if err := database.GetDB().Where("session_id = ?", sessionID).First(&req).Error; err == nil && !req.IsFinal() {
+		setStatus(&req, status, models.ReasonRecovered)
+	}
+}
+
+// takeResumable removes and returns the session the reconciler left for a device's lane.
+func (ds *DeviceService) takeResumable(deviceID uint) *resumableSession {
+	ds.lanesMu.Lock()
+	defer ds.lanesMu.Unlock()
+	resumable := ds.resumable[deviceID]
+	delete(ds.resumable, deviceID)
+	return resumable
+}
+
+// resumeSession finishes a recovered session in its device's lane.
+// The device never stopped (the retained ON command kept it running), so no command is sent.
+func (ds *DeviceService) resumeSession(r *resumableSession) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("[Panic] Session resume recovered: %v", rec)
+		}
+	}()
+
+	ctx, cancel := context.WithCancelCause(ds.base)
+	defer cancel(nil)
+
+	active := &activeActivation{
+		cancel:     cancel,
+		requestID:  r.req.ID,
+		userID:     r.session.UserID,
+		reschedule: make(chan struct{}, 1),
+		device:     r.device,
+	}
+	ds.activeActivationsMu.Lock()
+	ds.activeActivations[r.device.ID] = active
+	ds.activeActivationsMu.Unlock()
+	defer func() {
+		ds.activeActivationsMu.Lock()
+		delete(ds.activeActivations, r.device.ID)
+		ds.activeActivationsMu.Unlock()
+	}()
+
+	// The recovered session keeps its interlock group; the device is already ON
+	if r.device.InterlockGroup != "" {
+		ds.interlocksMu.Lock()
+		ds.interlocks[r.device.InterlockGroup] = r.device.ID
+		ds.interlocksMu.Unlock()
+		defer func() {
+			if r.device.State != models.StateFault {
+				ds.releaseInterlock(&r.device)
+			}
+		}()
+	}
+
+	ds.runSession(ctx, active, r.req, &r.device, &r.session, r.session.StartedAt, r.session.ActiveUntil, models.ReasonRecovered)
+}
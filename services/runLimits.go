@@ -0,0 +1,89 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+// Run time limits reported in LimitError.Limit
+const (
+	LimitMinRunTime           = "min_run_time"
+	LimitMaxRunTime           = "max_run_time"
+	LimitMaxContinuousRuntime = "max_continuous_runtime"
+)
+
+// continuousRunGap is the shortest rest that ends a continuous run; sessions closer together count as one run.
+const continuousRunGap = 5 * time.Minute
+
+// LimitError reports that a requested run time breaks one of the device's run time limits.
+type LimitError struct {
+	DeviceID  uint
+	Limit     string        // LimitMinRunTime, LimitMaxRunTime or LimitMaxContinuousRuntime
+	Allowed   time.Duration // The device's setting
+	Requested time.Duration // The run time that was asked for (the whole run for the continuous cap)
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case LimitMinRunTime:
+		return fmt.Sprintf("duration %v is below the device's minimum run time of %v", e.Requested, e.Allowed)
+	case LimitMaxRunTime:
+		return fmt.Sprintf("duration %v exceeds the device's maximum run time of %v", e.Requested, e.Allowed)
+	default:
+		return fmt.Sprintf("device %d would run continuously for %v, above its cap of %v", e.DeviceID, e.Requested.Round(time.Minute), e.Allowed)
+	}
+}
+
+// checkRunTime checks the duration of a new session against the device's minimum and maximum.
+func checkRunTime(device *models.Device, duration time.Duration) *LimitError {
+	if device.MinRunTime > 0 && duration < device.MinRunTime {
+		return &LimitError{DeviceID: device.ID, Limit: LimitMinRunTime, Allowed: device.MinRunTime, Requested: duration}
+	}
+	if device.MaxRunTime > 0 && duration > device.MaxRunTime {
+		return &LimitError{DeviceID: device.ID, Limit: LimitMaxRunTime, Allowed: device.MaxRunTime, Requested: duration}
+	}
+	return nil
+}
+
+// checkContinuousRuntime checks that a session starting at start (or when the device's current
+// session ends, if later) and lasting duration keeps the device's continuous run under its cap.
+// excludeSessionID leaves out the session being adjusted.
+func checkContinuousRuntime(device *models.Device, start time.Time, duration time.Duration, excludeSessionID uint) *LimitError {
+	if device.MaxContinuousRuntime <= 0 {
+		return nil
+	}
+
+	var sessions []models.DeviceSession
+	database.GetDB().
+		Where("device_id = ? AND id <> ? AND started_at < ?", device.ID, excludeSessionID, start.Add(duration)).
+		Order("started_at DESC").
+		Limit(100).
+		Find(&sessions)
+
+	// Walk back through sessions separated by less than continuousRunGap
+	runStart, sessionStart := start, start
+	for _, session := range sessions {
+		sessionEnd := session.ActiveUntil
+		if session.EndedAt != nil {
+			sessionEnd = *session.EndedAt
+		}
+		if sessionEnd.After(sessionStart) {
+			// Still running: the new session starts when this one ends
+			sessionStart = sessionEnd
+		}
+		if runStart.Sub(sessionEnd) >= continuousRunGap {
+			break
+		}
+		if session.StartedAt.Before(runStart) {
+			runStart = session.StartedAt
+		}
+	}
+
+	if total := sessionStart.Add(duration).Sub(runStart); total > device.MaxContinuousRuntime {
+		return &LimitError{DeviceID: device.ID, Limit: LimitMaxContinuousRuntime, Allowed: device.MaxContinuousRuntime, Requested: total}
+	}
+	return nil
+}
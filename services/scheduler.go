@@ -0,0 +1,169 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+const (
+	schedulerInterval   = 30 * time.Second // How often due schedules are checked
+	scheduleMissedGrace = 15 * time.Minute // Runs older than this (e.g. after downtime) are skipped, not queued late
+)
+
+// Scheduler queues activations for recurring schedules.
+type Scheduler struct {
+	deviceService *DeviceService
+	once          sync.Once
+	stop          chan struct{}
+	stopOnce      sync.Once
+}
+
+// NewScheduler creates a scheduler that feeds the given device service.
+func NewScheduler(deviceService *DeviceService) *Scheduler {
+	return &Scheduler{deviceService: deviceService, stop: make(chan struct{})}
+}
+
+// Start launches the scheduler loop (only once).
+func (s *Scheduler) Start() {
+	s.once.Do(func() {
+		go s.loop()
+	})
+}
+
+// Stop ends the scheduler loop; due runs are picked up by the next instance.
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *Scheduler) loop() {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+	for {
+		s.runDue(time.Now())
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// runDue fires every enabled schedule whose next run is due.
+func (s *Scheduler) runDue(now time.Time) {
+	var due []models.Schedule
+	if err := database.GetDB().
+		Where("enabled = ? AND next_run_at IS NOT NULL AND next_run_at <= ?", true, now).
+		Find(&due).Error; err != nil {
+		log.Printf("[Schedule] Failed to fetch due schedules: %v", err)
+		return
+	}
+	for i := range due {
+		s.fire(&due[i], now)
+	}
+}
+
+// fire queues one run of a schedule (unless it is skipped or missed) and advances it to the next occurrence.
+func (s *Scheduler) fire(schedule *models.Schedule, now time.Time) {
+	runAt := *schedule.NextRunAt
+	updates := map[string]interface{}{}
+
+	switch {
+	case schedule.SkipNext:
+		log.Printf("[Schedule] Skipping run of schedule %d at %s as requested", schedule.ID, runAt.Format(time.RFC3339))
+		updates["skip_next"] = false
+	case now.Sub(runAt) > scheduleMissedGrace:
+		log.Printf("[Schedule] Missed run of schedule %d at %s", schedule.ID, runAt.Format(time.RFC3339))
+	default:
+		scheduleID := schedule.ID
+		activation, err := s.deviceService.EnqueueActivation(&DeviceRequest{
+			UserID:     schedule.UserID,
+			DeviceID:   schedule.DeviceID,
+			Duration:   schedule.Duration,
+			Origin:     models.OriginSchedule,
+			ScheduleID: &scheduleID,
+		})
+		if err != nil {
+			log.Printf("[Schedule] Failed to queue run of schedule %d: %v", schedule.ID, err)
+			SendDevicePushNotificationToUser(
+				schedule.UserID,
+				schedule.DeviceID,
+				fmt.Sprintf("Scheduled run %q could not start: %v", schedule.Name, err),
+				map[string]string{
+					"schedule_id": fmt.Sprintf("%d", schedule.ID),
+					"action":      "schedule_failed",
+				},
+			)
+		} else {
+			log.Printf("[Schedule] Schedule %d queued activation %d", schedule.ID, activation.ID)
+		}
+		updates["last_run_at"] = now
+	}
+
+	next, err := NextScheduleRun(schedule, now)
+	if err != nil {
+		log.Printf("[Schedule] Schedule %d is invalid, disabling it: %v", schedule.ID, err)
+		updates["enabled"] = false
+	}
+	updates["next_run_at"] = next
+	if err := database.GetDB().Model(schedule).Updates(updates).Error; err != nil {
+		log.Printf("[Schedule] Failed to advance schedule %d: %v", schedule.ID, err)
+	}
+}
+
+// ScheduleLocation returns the timezone a schedule is evaluated in.
+func ScheduleLocation(schedule *models.Schedule) (*time.Location, error) {
+	if schedule.Timezone == "" {
+		return Settings().Location(), nil
+	}
+	return time.LoadLocation(schedule.Timezone)
+}
+
+// NextScheduleRun returns the first occurrence of the schedule strictly after the given time,
+// honouring its timezone, weekday filter and date range. It returns nil when there are no more runs.
+func NextScheduleRun(schedule *models.Schedule, after time.Time) (*time.Time, error) {
+	cron, err := ParseCron(schedule.CronExpr)
+	if err != nil {
+		return nil, err
+	}
+	days, err := ParseDaysOfWeek(schedule.DaysOfWeek)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := ScheduleLocation(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	t := after.In(loc)
+	if schedule.StartDate != nil {
+		y, m, d := schedule.StartDate.Date()
+		if start := time.Date(y, m, d, 0, 0, 0, 0, loc); t.Before(start) {
+			t = start.Add(-time.Minute)
+		}
+	}
+	var end time.Time
+	if schedule.EndDate != nil {
+		y, m, d := schedule.EndDate.Date()
+		end = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+	}
+
+	// Bound the search in case the weekday filter never agrees with the cron expression
+	for i := 0; i < 1000; i++ {
+		t = cron.Next(t)
+		if t.IsZero() || (!end.IsZero() && !t.Before(end)) {
+			return nil, nil
+		}
+		if days != nil && !days[t.Weekday()] {
+			continue
+		}
+		return &t, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,25 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/musabgulfam/pumplink-backend/config"
+)
+
+var (
+	settings     *config.Config
+	settingsOnce sync.Once
+)
+
+// Configure sets the configuration the services run with. main calls it once at startup with the
+// configuration it loaded, so hot paths never re-read the environment.
+func Configure(cfg *config.Config) {
+	settingsOnce.Do(func() { settings = cfg })
+}
+
+// Settings returns the configuration set by Configure, loading it from the environment
+// the first time if Configure was not called.
+func Settings() *config.Config {
+	settingsOnce.Do(func() { settings = config.Load() })
+	return settings
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+// What a graceful shutdown does with running activations
+const (
+	ShutdownStop    = "stop"    // Turn the devices OFF and close their sessions
+	ShutdownHandoff = "handoff" // Leave the devices ON for the next instance to resume
+)
+
+// causeHandoff stops an activation without turning the device OFF.
+const causeHandoff = "handoff"
+
+// shutdownFaultMargin is how long before the shutdown deadline OFF verification gives up and
+// marks the device FAULT, so the fault is recorded before the process exits.
+const shutdownFaultMargin = 5 * time.Second
+
+// Shutdown stops the activator. Lanes stop taking queued requests, which stay in the queue
+// for the next instance, and running activations are stopped or handed off per policy.
+// It waits for the lanes to finish until ctx is done.
+func (ds *DeviceService) Shutdown(ctx context.Context, policy string) error {
+	cause := &stopCause{reason: models.ReasonShutdown, detail: "backend shut down"}
+	if policy == ShutdownHandoff {
+		cause = &stopCause{reason: causeHandoff}
+	}
+	log.Printf("[Shutdown] Stopping the activator (%s running activations)", policy)
+	if deadline, ok := ctx.Deadline(); ok {
+		ds.shutdownBy = deadline
+	}
+	ds.stopAll(cause)
+
+	// No lane can start once base is cancelled; taking the lock waits out one being started
+	ds.lanesMu.Lock()
+	ds.lanesMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ds.lanesWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Println("[Shutdown] All activations stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// offVerificationDeadline returns when OFF verification must give up, or the zero time when
+// the service is not shutting down (or the shutdown has no deadline).
+func (ds *DeviceService) offVerificationDeadline() time.Time {
+	if ds.base.Err() == nil || ds.shutdownBy.IsZero() {
+		return time.Time{}
+	}
+	return ds.shutdownBy.Add(-shutdownFaultMargin)
+}
+
+// requeue puts a request that was interrupted before it started back in the queue.
+func requeue(req *models.ActivationRequest, reason string) {
+	req.Status = models.ActivationQueued
+	req.StatusReason = reason
+	req.FinishedAt = nil
+	if err := database.GetDB().Model(req).Updates(map[string]interface{}{
+		"status":        models.ActivationQueued,
+		"status_reason": reason,
+		"finished_at":   nil,
+	}).Error; err != nil {
+		log.Printf("[Queue] Failed to requeue request %d: %v", req.ID, err)
+	}
+}
+
+// handOffSession leaves a running session open for the next instance to resume.
+func handOffSession(session *models.DeviceSession, deadline time.Time) {
+	now := time.Now()
+	if err := database.GetDB().Model(session).Updates(map[string]interface{}{
+		"ActiveUntil": deadline,
+		"HandedOffAt": now,
+	}).Error; err != nil {
+		log.Printf("[Shutdown] Failed to hand off session %d: %v", session.ID, err)
+		return
+	}
+	log.Printf("[Shutdown] Session %d on device %d handed off with %v remaining", session.ID, session.DeviceID, time.Until(deadline).Round(time.Second))
+}
@@ -2,18 +2,64 @@ package services
 
 import (
 	"log"
+	"time"
 
 	mqttlib "github.com/eclipse/paho.mqtt.golang"
 )
 
-// SubscribeToDeviceStatus subscribes to all device status topics and broadcasts updates to WebSocket clients.
+// SubscribeToDeviceStatus subscribes to all device status topics, stores versioned telemetry
+// and broadcasts updates to WebSocket clients. Messages that fail device verification are dropped.
 func SubscribeToDeviceStatus() {
 	Subscribe(MQTTTopicDeviceStatus, func(client mqttlib.Client, msg mqttlib.Message) {
 		topic := msg.Topic()
-		payload := string(msg.Payload())
+		payload := msg.Payload()
 
 		log.Printf("MQTT message: %s -> %s\n", topic, payload)
+		if deviceID, ok := ParseDeviceTopicID(topic); ok {
+			body, err := VerifyDeviceMessage(deviceID, topic, payload)
+			if err != nil {
+				log.Printf("[Auth] Rejected status on %s: %v", topic, err)
+				return
+			}
+			payload = body
+			// Every replica relays status to its clients; only the leader records it
+			if IsLeader() {
+				TouchDevice(deviceID)
+				handleTelemetry(deviceID, payload)
+			}
+		}
 		// Broadcast the message to all WebSocket clients
-		Broadcast(payload)
+		Broadcast(string(payload))
+	})
+}
+
+// handleTelemetry validates and stores a status payload. Legacy plain-text payloads are only broadcast.
+func handleTelemetry(deviceID uint, payload []byte) {
+	telemetry, err := ParseTelemetry(deviceID, payload, time.Now())
+	if err == ErrNotTelemetry {
+		return
+	}
+	if err != nil {
+		log.Printf("[Telemetry] Rejected status from device %d: %v", deviceID, err)
+		return
+	}
+	if err := StoreTelemetry(telemetry); err != nil {
+		log.Printf("[Telemetry] Failed to store status from device %d: %v", deviceID, err)
+	}
+	for _, listener := range telemetryListeners {
+		listener(telemetry)
+	}
+}
+
+// SubscribeToDeviceHeartbeats subscribes to device heartbeats, which only keep the device marked online.
+func SubscribeToDeviceHeartbeats() {
+	Subscribe(MQTTTopicDeviceHeartbeat, func(client mqttlib.Client, msg mqttlib.Message) {
+		if deviceID, ok := ParseDeviceTopicID(msg.Topic()); ok {
+			if _, err := VerifyDeviceMessage(deviceID, msg.Topic(), msg.Payload()); err != nil {
+				log.Printf("[Auth] Rejected heartbeat on %s: %v", msg.Topic(), err)
+				return
+			}
+			TouchDevice(deviceID)
+		}
 	})
 }
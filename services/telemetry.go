@@ -0,0 +1,153 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/musabgulfam/pumplink-backend/database"
+	"github.com/musabgulfam/pumplink-backend/models"
+)
+
+// TelemetrySchemaVersion is the current version of the JSON status payload.
+const TelemetrySchemaVersion = 1
+
+// maxTelemetryClockSkew is how far a device timestamp may be in the future before it is rejected.
+const maxTelemetryClockSkew = 5 * time.Minute
+
+var ErrNotTelemetry = errors.New("payload is not a versioned status message")
+
+// TelemetryPayload is the JSON status message published on device/{id}/status:
+//
+//	{"v": 1, "ts": 1754290678, "current": 4.2, "voltage": 228.5, "flow_rate": 12.3,
+//	 "pressure": 1.8, "relay": "on", "rssi": -67}
+//
+// Every reading is optional; "ts" is a Unix timestamp in seconds.
+type TelemetryPayload struct {
+	Version  int      `json:"v"`
+	Time     *int64   `json:"ts"`
+	Current  *float64 `json:"current"`
+	Voltage  *float64 `json:"voltage"`
+	FlowRate *float64 `json:"flow_rate"`
+	Pressure *float64 `json:"pressure"`
+	Relay    *string  `json:"relay"`
+	RSSI     *int     `json:"rssi"`
+}
+
+// TelemetryPoint is one bucket of a downsampled telemetry series.
+type TelemetryPoint struct {
+	Time     time.Time `json:"time"`
+	Current  *float64  `json:"current"`
+	Voltage  *float64  `json:"voltage"`
+	FlowRate *float64  `json:"flow_rate"`
+	Pressure *float64  `json:"pressure"`
+	RelayOn  *bool     `json:"relay_on"` // True if the relay was on at any point in the bucket
+	RSSI     *float64  `json:"rssi"`
+	Samples  int       `json:"samples"`
+}
+
+// ParseTelemetry decodes and validates a status payload.
+// It returns ErrNotTelemetry for legacy payloads that are not versioned JSON.
+func ParseTelemetry(deviceID uint, payload []byte, receivedAt time.Time) (*models.DeviceTelemetry, error) {
+	var msg TelemetryPayload
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.Version == 0 {
+		return nil, ErrNotTelemetry
+	}
+	if msg.Version != TelemetrySchemaVersion {
+		return nil, fmt.Errorf("unsupported schema version %d", msg.Version)
+	}
+
+	recordedAt := receivedAt
+	if msg.Time != nil {
+		recordedAt = time.Unix(*msg.Time, 0)
+		if recordedAt.After(receivedAt.Add(maxTelemetryClockSkew)) {
+			return nil, fmt.Errorf("timestamp %d is in the future", *msg.Time)
+		}
+	}
+
+	if err := checkRange("current", msg.Current, 0, 500); err != nil {
+		return nil, err
+	}
+	if err := checkRange("voltage", msg.Voltage, 0, 1000); err != nil {
+		return nil, err
+	}
+	if err := checkRange("flow_rate", msg.FlowRate, 0, 100000); err != nil {
+		return nil, err
+	}
+	if err := checkRange("pressure", msg.Pressure, 0, 100); err != nil {
+		return nil, err
+	}
+	if msg.RSSI != nil && (*msg.RSSI < -150 || *msg.RSSI > 0) {
+		return nil, fmt.Errorf("rssi %d out of range", *msg.RSSI)
+	}
+
+	telemetry := &models.DeviceTelemetry{
+		DeviceID:      deviceID,
+		RecordedAt:    recordedAt,
+		SchemaVersion: msg.Version,
+		Current:       msg.Current,
+		Voltage:       msg.Voltage,
+		FlowRate:      msg.FlowRate,
+		Pressure:      msg.Pressure,
+		RSSI:          msg.RSSI,
+	}
+	if msg.Relay != nil {
+		var on bool
+		switch strings.ToLower(*msg.Relay) {
+		case "on":
+			on = true
+		case "off":
+			on = false
+		default:
+			return nil, fmt.Errorf("relay must be \"on\" or \"off\", got %q", *msg.Relay)
+		}
+		telemetry.RelayOn = &on
+	}
+	return telemetry, nil
+}
+
+// checkRange validates an optional reading.
+func checkRange(name string, value *float64, min, max float64) error {
+	if value != nil && (*value < min || *value > max) {
+		return fmt.Errorf("%s %v out of range (%v-%v)", name, *value, min, max)
+	}
+	return nil
+}
+
+// telemetryListeners are called with every stored sample.
+var telemetryListeners []func(*models.DeviceTelemetry)
+
+// OnTelemetry registers a function to be called with every stored telemetry sample.
+// Listeners must be registered before the MQTT subscriptions are made.
+func OnTelemetry(listener func(*models.DeviceTelemetry)) {
+	telemetryListeners = append(telemetryListeners, listener)
+}
+
+// StoreTelemetry saves a validated sample.
+func StoreTelemetry(telemetry *models.DeviceTelemetry) error {
+	return database.GetDB().Create(telemetry).Error
+}
+
+// TelemetrySeries returns a device's telemetry between from and to, averaged into buckets of the given resolution.
+func TelemetrySeries(deviceID uint, from, to time.Time, resolution time.Duration) ([]TelemetryPoint, error) {
+	seconds := resolution.Seconds()
+	var points []TelemetryPoint
+	err := database.GetDB().Raw(`
+		SELECT to_timestamp(floor(extract(epoch FROM recorded_at) / ?) * ?) AS time,
+		       AVG(current) AS current,
+		       AVG(voltage) AS voltage,
+		       AVG(flow_rate) AS flow_rate,
+		       AVG(pressure) AS pressure,
+		       BOOL_OR(relay_on) AS relay_on,
+		       AVG(rssi) AS rssi,
+		       COUNT(*) AS samples
+		FROM device_telemetries
+		WHERE device_id = ? AND recorded_at >= ? AND recorded_at < ? AND deleted_at IS NULL
+		GROUP BY 1
+		ORDER BY 1`,
+		seconds, seconds, deviceID, from, to,
+	).Scan(&points).Error
+	return points, err
+}
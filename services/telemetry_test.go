@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTelemetry(t *testing.T) {
+	received := time.Unix(1754290678, 0)
+
+	t.Run("full sample", func(t *testing.T) {
+		payload := `{"v": 1, "ts": 1754290600, "current": 4.2, "voltage": 228.5, "flow_rate": 12.3, "pressure": 1.8, "relay": "ON", "rssi": -67}`
+		got, err := ParseTelemetry(3, []byte(payload), received)
+		if err != nil {
+			t.Fatalf("ParseTelemetry: %v", err)
+		}
+		if got.DeviceID != 3 || got.SchemaVersion != 1 || !got.RecordedAt.Equal(time.Unix(1754290600, 0)) {
+			t.Errorf("got device %d, version %d, recorded at %v", got.DeviceID, got.SchemaVersion, got.RecordedAt)
+		}
+		if got.Current == nil || *got.Current != 4.2 || got.Voltage == nil || *got.Voltage != 228.5 {
+			t.Errorf("readings not copied: current %v, voltage %v", got.Current, got.Voltage)
+		}
+		if got.RelayOn == nil || !*got.RelayOn {
+			t.Errorf("RelayOn = %v, want true", got.RelayOn)
+		}
+		if got.RSSI == nil || *got.RSSI != -67 {
+			t.Errorf("RSSI = %v, want -67", got.RSSI)
+		}
+	})
+
+	t.Run("readings are optional", func(t *testing.T) {
+		got, err := ParseTelemetry(3, []byte(`{"v": 1, "relay": "off"}`), received)
+		if err != nil {
+			t.Fatalf("ParseTelemetry: %v", err)
+		}
+		if !got.RecordedAt.Equal(received) {
+			t.Errorf("RecordedAt = %v, want the receive time %v", got.RecordedAt, received)
+		}
+		if got.Current != nil || got.FlowRate != nil || got.RelayOn == nil || *got.RelayOn {
+			t.Errorf("got current %v, flow %v, relay %v", got.Current, got.FlowRate, got.RelayOn)
+		}
+	})
+
+	legacy := []string{`ON`, `{"status": "on"}`, `{"v": 0, "current": 1}`, ``}
+	for _, payload := range legacy {
+		if _, err := ParseTelemetry(3, []byte(payload), received); !errors.Is(err, ErrNotTelemetry) {
+			t.Errorf("ParseTelemetry(%q) error = %v, want ErrNotTelemetry", payload, err)
+		}
+	}
+
+	invalid := []struct {
+		name    string
+		payload string
+	}{
+		{"future schema", `{"v": 2}`},
+		{"too far in the future", `{"v": 1, "ts": 1754291000}`},
+		{"negative current", `{"v": 1, "current": -0.5}`},
+		{"voltage out of range", `{"v": 1, "voltage": 1500}`},
+		{"pressure out of range", `{"v": 1, "pressure": 120}`},
+		{"positive rssi", `{"v": 1, "rssi": 5}`},
+		{"unknown relay state", `{"v": 1, "relay": "tripped"}`},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTelemetry(3, []byte(tt.payload), received)
+			if err == nil || errors.Is(err, ErrNotTelemetry) {
+				t.Errorf("ParseTelemetry(%s) error = %v, want a validation error", tt.payload, err)
+			}
+		})
+	}
+}
@@ -1,12 +1,22 @@
 package services
 
 import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/musabgulfam/pumplink-backend/database"
 )
 
+// backendEventsChannel is the Postgres notification channel WebSocket events are relayed on.
+// Only the backends can reach the database, so devices and other broker clients cannot inject events.
+const backendEventsChannel = "pumplink_events"
+
 type WSManager struct {
 	clients      map[*websocket.Conn]bool
 	mu           sync.Mutex
@@ -52,3 +62,107 @@ func Broadcast(msg string) {
 	}
 	manager.mu.Unlock()
 }
+
+// CloseClients tells every connected client the server is going away and disconnects it
+func CloseClients() {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for client := range manager.clients {
+		client.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		client.Close()
+		delete(manager.clients, client)
+	}
+}
+
+// BroadcastEvent sends a JSON event to all connected clients without replacing the latest status.
+// The event is also relayed to the other replicas, whose clients would otherwise miss it.
+func BroadcastEvent(event interface{}) {
+	msg, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[WEBSOCKET] failed to encode event: %v", err)
+		return
+	}
+	broadcastEventLocal(msg)
+
+	envelope, err := json.Marshal(relayedEvent{From: instanceID, Event: msg})
+	if err != nil {
+		return
+	}
+	if err := database.GetDB().Exec("SELECT pg_notify(?, ?)", backendEventsChannel, string(envelope)).Error; err != nil {
+		log.Printf("[WEBSOCKET] failed to relay event: %v", err)
+	}
+}
+
+// broadcastEventLocal writes an encoded event to the clients connected to this replica.
+func broadcastEventLocal(msg []byte) {
+	manager.mu.Lock()
+	for client := range manager.clients {
+		if err := client.WriteMessage(websocket.TextMessage, msg); err != nil {
+			log.Printf("[WEBSOCKET] broadcast error: %v", err)
+			client.Close()
+			delete(manager.clients, client)
+		}
+	}
+	manager.mu.Unlock()
+}
+
+// relayedEvent is a WebSocket event passed between replicas through Postgres notifications.
+type relayedEvent struct {
+	From  string          `json:"from"` // Replica that raised the event
+	Event json.RawMessage `json:"event"`
+}
+
+// instanceID tells this replica's relayed events apart from the others'.
+var instanceID = newCommandID()
+
+// ListenForBackendEvents delivers events raised on other replicas to this replica's clients
+// until ctx is done, reconnecting if the listening connection is lost.
+func ListenForBackendEvents(ctx context.Context) {
+	go func() {
+		for ctx.Err() == nil {
+			if err := listenBackendEvents(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("[WEBSOCKET] Backend event listener stopped: %v", err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(5 * time.Second):
+				}
+			}
+		}
+	}()
+}
+
+// listenBackendEvents listens for relayed events on a dedicated connection until it fails or ctx is done.
+func listenBackendEvents(ctx context.Context) error {
+	sqlDB, err := database.GetDB().DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var listenErr error
+	conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		if _, listenErr = pgConn.Exec(ctx, "LISTEN "+backendEventsChannel); listenErr != nil {
+			return driver.ErrBadConn
+		}
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				listenErr = err
+				// The connection is still listening, so it must not go back to the pool
+				return driver.ErrBadConn
+			}
+			var relayed relayedEvent
+			if err := json.Unmarshal([]byte(notification.Payload), &relayed); err != nil || relayed.From == instanceID {
+				continue
+			}
+			broadcastEventLocal(relayed.Event)
+		}
+	})
+	return listenErr
+}